@@ -56,3 +56,9 @@ func (e *AggregatedError) Error() string {
 	}
 	return ""
 }
+
+// Unwrap returns the contained errors, so errors.Is and errors.As
+// match any of them
+func (e *AggregatedError) Unwrap() []error {
+	return e.Errors
+}
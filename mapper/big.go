@@ -0,0 +1,80 @@
+package mapper
+
+import (
+	"math"
+	"math/big"
+	"reflect"
+)
+
+var (
+	bigIntType   = reflect.TypeOf(big.Int{})
+	bigFloatType = reflect.TypeOf(big.Float{})
+)
+
+func errParseNumber(value string, to reflect.Type, loc string) error {
+	return &ParseNumberError{Loc: loc, Value: value, To: to}
+}
+
+func isBigType(t reflect.Type) bool {
+	return t == bigIntType || t == bigFloatType
+}
+
+// decimalPrec is the precision of big.Float for a decimal string, which
+// keeps all the digits, as 4 bits are more than a decimal digit needs.
+// The default precision of big.Float is 64 bits, about 19 digits
+func decimalPrec(str string) uint {
+	if prec := uint(len(str)) * 4; prec > 64 {
+		return prec
+	}
+	return 64
+}
+
+// assignToBig assigns an int, uint, float or string to big.Int or
+// big.Float, and other sources are assigned as structs
+func (m *Mapper) assignToBig(d, s reflect.Value, loc string) (assigned bool, err error) {
+	class := TypeClass(s.Kind())
+	if class != IntClass && class != UintClass && class != FloatClass && class != StringClass {
+		return m.assignToStruct(d, s, loc)
+	}
+	if !d.CanSet() {
+		return false, errNoSetValue(loc)
+	}
+	f := new(big.Float).SetPrec(0)
+	switch class {
+	case IntClass:
+		f.SetInt64(s.Int())
+	case UintClass:
+		f.SetUint64(s.Uint())
+	case FloatClass:
+		if math.IsNaN(s.Float()) || math.IsInf(s.Float(), 0) {
+			return false, errMismatch(s.Type(), d.Type(), loc)
+		}
+		f.SetFloat64(s.Float())
+	case StringClass:
+		if d.Type() == bigIntType {
+			i, ok := new(big.Int).SetString(s.String(), 0)
+			if !ok {
+				return false, errParseNumber(s.String(), d.Type(), loc)
+			}
+			d.Set(reflect.ValueOf(i).Elem())
+			m.traceConvert(s.Type(), d.Type(), loc)
+			return true, nil
+		}
+		parsed, _, err := big.ParseFloat(s.String(), 0, decimalPrec(s.String()), big.ToNearestEven)
+		if err != nil {
+			return false, errParseNumber(s.String(), d.Type(), loc)
+		}
+		f = parsed
+	}
+	if d.Type() == bigIntType {
+		i, accuracy := f.Int(nil)
+		if accuracy != big.Exact {
+			return false, errMismatch(s.Type(), d.Type(), loc)
+		}
+		d.Set(reflect.ValueOf(i).Elem())
+	} else {
+		d.Set(reflect.ValueOf(f).Elem())
+	}
+	m.traceConvert(s.Type(), d.Type(), loc)
+	return true, nil
+}
@@ -0,0 +1,65 @@
+package mapper
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"reflect"
+)
+
+// ByteSliceFormat defines how byte slices are represented in maps,
+// as JSON and YAML have no binary type
+type ByteSliceFormat int
+
+// Byte slice formats
+const (
+	// BytesRaw doesn't convert byte slices
+	BytesRaw ByteSliceFormat = iota
+	// BytesBase64 represents a byte slice as a standard base64 string
+	BytesBase64
+	// BytesHex represents a byte slice as a hex string
+	BytesHex
+)
+
+func isByteSlice(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8
+}
+
+// assignBytes decodes a string in ByteSliceFormat into a byte slice
+func (m *Mapper) assignBytes(d, s reflect.Value, loc string) (assigned bool, err error) {
+	if m.ByteSliceFormat == BytesRaw || s.Kind() != reflect.String || !isByteSlice(d.Type()) {
+		return false, nil
+	}
+	if !d.CanSet() {
+		return false, errNoSetValue(loc)
+	}
+	var b []byte
+	if m.ByteSliceFormat == BytesHex {
+		b, err = hex.DecodeString(s.String())
+	} else {
+		b, err = base64.StdEncoding.DecodeString(s.String())
+	}
+	if err != nil {
+		return false, &DecodeBytesError{Loc: loc, Err: err}
+	}
+	d.Set(reflect.ValueOf(b).Convert(d.Type()))
+	m.traceConvert(s.Type(), d.Type(), loc)
+	return true, nil
+}
+
+// formatBytes converts a byte slice to ByteSliceFormat
+func (m *Mapper) formatBytes(v reflect.Value) reflect.Value {
+	u := UnwrapAny(v)
+	if !u.IsValid() || !isByteSlice(u.Type()) {
+		return v
+	}
+	var out interface{}
+	switch m.ByteSliceFormat {
+	case BytesBase64:
+		out = base64.StdEncoding.EncodeToString(u.Bytes())
+	case BytesHex:
+		out = hex.EncodeToString(u.Bytes())
+	default:
+		return v
+	}
+	return reflect.ValueOf(&out).Elem()
+}
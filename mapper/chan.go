@@ -0,0 +1,54 @@
+package mapper
+
+import (
+	"reflect"
+	"strconv"
+)
+
+// assignToChan sends the elements of a slice into the channel with
+// ChannelIO, and creates a buffered channel if the destination is nil.
+// It never blocks, and fails if the channel is full
+func (m *Mapper) assignToChan(d, s reflect.Value, loc string) (assigned bool, err error) {
+	if !m.ChannelIO || TypeClass(s.Kind()) != SliceClass || d.Type().ChanDir()&reflect.SendDir == 0 {
+		return false, nil
+	}
+	elems := make([]reflect.Value, s.Len())
+	for i := range elems {
+		elems[i] = reflect.New(d.Type().Elem()).Elem()
+		if _, err = m.assignValue(elems[i], s.Index(i), m.locExp(loc, strconv.Itoa(i))); err != nil {
+			return false, err
+		}
+	}
+	if d.IsNil() {
+		if !d.CanSet() {
+			return false, errNoSetValue(loc)
+		}
+		d.Set(reflect.MakeChan(reflect.ChanOf(reflect.BothDir, d.Type().Elem()), len(elems)).Convert(d.Type()))
+	}
+	for i, elem := range elems {
+		if !d.TrySend(elem) {
+			return false, &ChannelFullError{Loc: m.locExp(loc, strconv.Itoa(i)), Cap: d.Cap()}
+		}
+	}
+	m.traceConvert(s.Type(), d.Type(), loc)
+	return true, nil
+}
+
+// assignFromChan receives the elements from the channel into a slice with
+// ChannelIO, until the channel is empty or closed, or ChannelBound elements
+// are received. It never blocks
+func (m *Mapper) assignFromChan(d, s reflect.Value, loc string) (assigned bool, err error) {
+	if s.IsNil() || s.Type().ChanDir()&reflect.RecvDir == 0 {
+		return false, nil
+	}
+	elems := reflect.MakeSlice(reflect.SliceOf(s.Type().Elem()), 0, s.Len())
+	for m.ChannelBound <= 0 || elems.Len() < m.ChannelBound {
+		v, ok := s.TryRecv()
+		if !ok {
+			break
+		}
+		elems = reflect.Append(elems, v)
+	}
+	m.traceConvert(s.Type(), elems.Type(), loc)
+	return m.assignToSlice(d, elems, loc)
+}
@@ -0,0 +1,105 @@
+package mapper
+
+import (
+	"reflect"
+	"strconv"
+)
+
+// ComplexFormat defines how complex numbers are represented in maps,
+// as JSON and YAML have no complex type
+type ComplexFormat int
+
+// Complex number formats
+const (
+	// ComplexAsIs doesn't convert complex numbers
+	ComplexAsIs ComplexFormat = iota
+	// ComplexMap represents a complex number as {"real": 1, "imag": 2}
+	ComplexMap
+	// ComplexString represents a complex number as "(1+2i)"
+	ComplexString
+)
+
+func errComplex(loc string) error {
+	return &ComplexError{Loc: loc}
+}
+
+func floatOf(v reflect.Value) (float64, bool) {
+	v = UnwrapInterface(v)
+	switch TypeClass(v.Kind()) {
+	case IntClass:
+		return float64(v.Int()), true
+	case UintClass:
+		return float64(v.Uint()), true
+	case FloatClass:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// parseComplex converts the source value in ComplexMap format to a complex
+// number, ok is false if the source is not in the format.
+// Strings in ComplexString format are converted by assignToOther
+func (m *Mapper) parseComplex(s reflect.Value, loc string) (c complex128, ok bool, err error) {
+	switch m.ComplexFormat {
+	case ComplexMap:
+		if s.Kind() != reflect.Map || s.Type().Key().Kind() != reflect.String {
+			return
+		}
+		ok = true
+		keyType := s.Type().Key()
+		re, reOk := floatOf(s.MapIndex(reflect.ValueOf("real").Convert(keyType)))
+		im, imOk := floatOf(s.MapIndex(reflect.ValueOf("imag").Convert(keyType)))
+		if !reOk || !imOk || s.Len() != 2 {
+			return 0, ok, errComplex(loc)
+		}
+		c = complex(re, im)
+	}
+	return
+}
+
+// assignComplexString parses a string in ComplexString format
+func (m *Mapper) assignComplexString(d, s reflect.Value, loc string) (bool, error) {
+	c, err := strconv.ParseComplex(s.String(), 128)
+	if err != nil {
+		return false, errComplex(loc)
+	}
+	if !d.CanSet() {
+		return false, errNoSetValue(loc)
+	}
+	d.SetComplex(c)
+	return true, nil
+}
+
+func (m *Mapper) assignToComplex(d, s reflect.Value, loc string) (assigned bool, err error) {
+	if assigned, err = m.assignToOther(d, s, loc); assigned || err != nil {
+		return
+	}
+	c, ok, err := m.parseComplex(s, loc)
+	if !ok || err != nil {
+		return false, err
+	}
+	if !d.CanSet() {
+		return false, errNoSetValue(loc)
+	}
+	d.SetComplex(c)
+	return true, nil
+}
+
+// formatComplex converts a complex number to ComplexFormat
+func (m *Mapper) formatComplex(v reflect.Value) reflect.Value {
+	u := UnwrapAny(v)
+	if TypeClass(u.Kind()) != ComplexClass {
+		return v
+	}
+	var out interface{}
+	switch m.ComplexFormat {
+	case ComplexMap:
+		c := u.Complex()
+		out = map[string]interface{}{"real": real(c), "imag": imag(c)}
+	case ComplexString:
+		out = strconv.FormatComplex(u.Complex(), 'g', -1, 128)
+	default:
+		return v
+	}
+	return reflect.ValueOf(&out).Elem()
+}
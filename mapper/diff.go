@@ -0,0 +1,179 @@
+package mapper
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// ChangeKind determines how a value is changed
+type ChangeKind int
+
+// Change kinds
+const (
+	// ChangeModified indicates the value is different
+	ChangeModified ChangeKind = iota
+	// ChangeAdded indicates the map key or slice element only exists in the new value
+	ChangeAdded
+	// ChangeRemoved indicates the map key or slice element only exists in the old value
+	ChangeRemoved
+)
+
+// Change is a difference found by Diff
+type Change struct {
+	Loc  string
+	Kind ChangeKind
+	// Old is nil if the value is added
+	Old interface{}
+	// New is nil if the value is removed
+	New interface{}
+}
+
+// Diff compares a and b with a default Mapper
+func Diff(a, b interface{}) ([]Change, error) {
+	return (&Mapper{}).Diff(a, b)
+}
+
+// Diff compares a and b, and reports the changes from a to b.
+// Structs are compared as the maps they are mapped to, so the field tags
+// apply, and maps are compared key by key in sorted order. Slices are
+// compared index-wise, and the elements beyond the shorter slice are
+// reported as added or removed. Other values are changed unless they
+// are equal after conversion, e.g. int 1 equals float64 1
+func (m *Mapper) Diff(a, b interface{}) ([]Change, error) {
+	var changes []Change
+	if err := m.diffValue(reflect.ValueOf(a), reflect.ValueOf(b), "", &changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// diffNormalize maps a struct into a map for comparison
+func (m *Mapper) diffNormalize(v reflect.Value, loc string) (reflect.Value, error) {
+	v = UnwrapAny(v)
+	if v.Kind() != reflect.Struct || isTextStruct(v.Type()) || isBigType(v.Type()) {
+		return v, nil
+	}
+	out := reflect.MakeMap(reflect.MapOf(StringType, InterfaceType))
+	if _, err := m.assignValue(out, v, loc); err != nil {
+		return reflect.Value{}, err
+	}
+	return out, nil
+}
+
+func (m *Mapper) diffValue(a, b reflect.Value, loc string, changes *[]Change) (err error) {
+	if a, err = m.diffNormalize(a, loc); err != nil {
+		return
+	}
+	if b, err = m.diffNormalize(b, loc); err != nil {
+		return
+	}
+	switch {
+	case a.Kind() == reflect.Map && b.Kind() == reflect.Map:
+		return m.diffMaps(a, b, loc, changes)
+	case isDiffList(a) && isDiffList(b):
+		return m.diffLists(a, b, loc, changes)
+	case !diffEqual(a, b):
+		*changes = append(*changes, Change{Loc: loc, Kind: ChangeModified, Old: diffInterface(a), New: diffInterface(b)})
+	}
+	return nil
+}
+
+func (m *Mapper) diffMaps(a, b reflect.Value, loc string, changes *[]Change) error {
+	keysA, keysB := diffKeys(a), diffKeys(b)
+	names := make([]string, 0, len(keysA)+len(keysB))
+	for name := range keysA {
+		names = append(names, name)
+	}
+	for name := range keysB {
+		if _, ok := keysA[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		keyA, inA := keysA[name]
+		keyB, inB := keysB[name]
+		switch {
+		case !inB:
+			*changes = append(*changes, Change{Loc: m.locExp(loc, name), Kind: ChangeRemoved,
+				Old: diffInterface(a.MapIndex(keyA))})
+		case !inA:
+			*changes = append(*changes, Change{Loc: m.locExp(loc, name), Kind: ChangeAdded,
+				New: diffInterface(b.MapIndex(keyB))})
+		default:
+			if err := m.diffValue(a.MapIndex(keyA), b.MapIndex(keyB), m.locExp(loc, name), changes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Mapper) diffLists(a, b reflect.Value, loc string, changes *[]Change) error {
+	for i := 0; i < a.Len() || i < b.Len(); i++ {
+		elemLoc := m.locExp(loc, strconv.Itoa(i))
+		switch {
+		case i >= b.Len():
+			*changes = append(*changes, Change{Loc: elemLoc, Kind: ChangeRemoved, Old: diffInterface(a.Index(i))})
+		case i >= a.Len():
+			*changes = append(*changes, Change{Loc: elemLoc, Kind: ChangeAdded, New: diffInterface(b.Index(i))})
+		default:
+			if err := m.diffValue(a.Index(i), b.Index(i), elemLoc, changes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func diffKeys(v reflect.Value) map[string]reflect.Value {
+	keys := make(map[string]reflect.Value, v.Len())
+	for _, key := range v.MapKeys() {
+		keys[fmt.Sprintf("%v", UnwrapAny(key))] = key
+	}
+	return keys
+}
+
+func isDiffList(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice:
+		return !isByteSlice(v.Type())
+	case reflect.Array:
+		return true
+	}
+	return false
+}
+
+// diffEqual compares the values, and converts one to the type of the
+// other if they are of different types
+func diffEqual(a, b reflect.Value) bool {
+	if !a.IsValid() || !b.IsValid() {
+		return a.IsValid() == b.IsValid()
+	}
+	if !a.CanInterface() || !b.CanInterface() {
+		return false
+	}
+	if reflect.DeepEqual(a.Interface(), b.Interface()) {
+		return true
+	}
+	if convFn := TypeConverterFactory(a.Type(), b.Type()); convFn != nil {
+		if v := convFn(a); v.IsValid() && reflect.DeepEqual(v.Interface(), b.Interface()) {
+			return true
+		}
+	}
+	if convFn := TypeConverterFactory(b.Type(), a.Type()); convFn != nil {
+		if v := convFn(b); v.IsValid() && reflect.DeepEqual(a.Interface(), v.Interface()) {
+			return true
+		}
+	}
+	return false
+}
+
+func diffInterface(v reflect.Value) interface{} {
+	if v = UnwrapInterface(v); v.IsValid() && v.CanInterface() {
+		return v.Interface()
+	}
+	return nil
+}
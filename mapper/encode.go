@@ -0,0 +1,117 @@
+package mapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// Encoder defines the interface for rendering the content, the reverse
+// of Decoder
+type Encoder interface {
+	Encode(v interface{}) ([]byte, error)
+}
+
+// NonFiniteHandling determines how Inf and NaN are encoded, as they are
+// not valid in JSON
+type NonFiniteHandling int
+
+// Non-finite handlings
+const (
+	// NonFiniteFail fails encoding with NonFiniteError, which is the default
+	NonFiniteFail NonFiniteHandling = iota
+	// NonFiniteNull encodes Inf and NaN as null
+	NonFiniteNull
+	// NonFiniteString encodes Inf and NaN as strings "+Inf", "-Inf" and "NaN"
+	NonFiniteString
+)
+
+// JSONEncoder encodes maps, slices and scalars in JSON, and structs are
+// mapped into maps first
+type JSONEncoder struct {
+	// Indent indents the output if not empty
+	Indent string
+	// NonFiniteHandling determines how Inf and NaN are encoded
+	NonFiniteHandling NonFiniteHandling
+	// Mapper maps structs into maps, and a default Mapper is used if nil
+	Mapper *Mapper
+}
+
+// Encode implements Encoder
+func (e *JSONEncoder) Encode(v interface{}) ([]byte, error) {
+	m := e.Mapper
+	if m == nil {
+		m = &Mapper{}
+	}
+	out, err := e.normalize(m, reflect.ValueOf(v), "")
+	if err != nil {
+		return nil, err
+	}
+	if e.Indent != "" {
+		return json.MarshalIndent(out, "", e.Indent)
+	}
+	return json.Marshal(out)
+}
+
+// normalize converts the value into maps, slices and scalars, and handles
+// non-finite floats, as encoding/json fails without the location. The locs
+// are composed by m, following Mapper.LocFormat
+func (e *JSONEncoder) normalize(m *Mapper, v reflect.Value, loc string) (interface{}, error) {
+	v = UnwrapAny(v)
+	if !v.IsValid() {
+		return nil, nil
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		if isTextStruct(v.Type()) || !v.CanInterface() {
+			break
+		}
+		out := make(map[string]interface{})
+		if err := m.Map(out, v.Interface()); err != nil {
+			return nil, err
+		}
+		return e.normalize(m, reflect.ValueOf(out), loc)
+	case reflect.Map:
+		out := make(map[string]interface{}, v.Len())
+		for _, key := range v.MapKeys() {
+			name := fmt.Sprintf("%v", UnwrapAny(key))
+			val, err := e.normalize(m, v.MapIndex(key), m.locExp(loc, name))
+			if err != nil {
+				return nil, err
+			}
+			out[name] = val
+		}
+		return out, nil
+	case reflect.Slice, reflect.Array:
+		if isByteSlice(v.Type()) {
+			break
+		}
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			val, err := e.normalize(m, v.Index(i), m.locExp(loc, strconv.Itoa(i)))
+			if err != nil {
+				return nil, err
+			}
+			out[i] = val
+		}
+		return out, nil
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if !math.IsInf(f, 0) && !math.IsNaN(f) {
+			break
+		}
+		switch e.NonFiniteHandling {
+		case NonFiniteNull:
+			return nil, nil
+		case NonFiniteString:
+			return strconv.FormatFloat(f, 'g', -1, 64), nil
+		}
+		return nil, &NonFiniteError{Loc: loc, Value: f}
+	}
+	if !v.CanInterface() {
+		return nil, &UnexportedValueError{Loc: loc, Type: v.Type()}
+	}
+	return v.Interface(), nil
+}
@@ -0,0 +1,82 @@
+package mapper
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type encodeItem struct {
+	Name  string  `map:"name"`
+	Score float64 `map:"score"`
+}
+
+type encodeStruct struct {
+	Title string       `map:"title"`
+	Items []encodeItem `map:"items"`
+}
+
+func TestJSONEncoder(t *testing.T) {
+	a := assert.New(t)
+	e := &JSONEncoder{}
+	out, err := e.Encode(&encodeStruct{Title: "t", Items: []encodeItem{{Name: "a", Score: 1.5}}})
+	if a.NoError(err) {
+		a.JSONEq(`{"title": "t", "items": [{"name": "a", "score": 1.5}]}`, string(out))
+	}
+	out, err = e.Encode(map[interface{}]interface{}{1: []byte("ab"), "b": nil})
+	if a.NoError(err) {
+		a.JSONEq(`{"1": "YWI=", "b": null}`, string(out))
+	}
+	e.Indent = "  "
+	out, err = e.Encode(map[string]int{"a": 1})
+	if a.NoError(err) {
+		a.Equal("{\n  \"a\": 1\n}", string(out))
+	}
+}
+
+func TestJSONEncoderNonFinite(t *testing.T) {
+	a := assert.New(t)
+	src := &encodeStruct{Items: []encodeItem{{Name: "a", Score: 1}, {Name: "b", Score: math.Inf(1)}}}
+	e := &JSONEncoder{}
+	var nonFinite *NonFiniteError
+	_, err := e.Encode(src)
+	if a.True(errors.As(err, &nonFinite)) {
+		a.Equal(".items.1.score", nonFinite.Loc)
+		a.True(math.IsInf(nonFinite.Value, 1))
+	}
+	_, err = e.Encode(map[string]interface{}{"a.b": []float64{math.NaN()}})
+	if a.True(errors.As(err, &nonFinite)) {
+		a.Equal(`.a\.b.0`, nonFinite.Loc)
+	}
+	e.Mapper = &Mapper{LocFormat: JSONPointer}
+	_, err = e.Encode(map[string]interface{}{"a/b": []float64{math.NaN()}})
+	if a.True(errors.As(err, &nonFinite)) {
+		a.Equal("/a~1b/0", nonFinite.Loc)
+	}
+	e.Mapper = nil
+
+	e.NonFiniteHandling = NonFiniteNull
+	out, err := e.Encode(src)
+	if a.NoError(err) {
+		a.JSONEq(`{"title": "", "items": [{"name": "a", "score": 1}, {"name": "b", "score": null}]}`, string(out))
+	}
+
+	e.NonFiniteHandling = NonFiniteString
+	out, err = e.Encode([]interface{}{math.Inf(1), math.Inf(-1), math.NaN(), float32(1.5)})
+	if a.NoError(err) {
+		a.JSONEq(`["+Inf", "-Inf", "NaN", 1.5]`, string(out))
+	}
+}
+
+func TestJSONEncoderUnexportedValue(t *testing.T) {
+	a := assert.New(t)
+	v := reflect.ValueOf(struct{ private []int }{private: []int{1}}).Field(0)
+	var unexported *UnexportedValueError
+	_, err := (&JSONEncoder{}).normalize(&Mapper{}, v, "")
+	if a.True(errors.As(err, &unexported)) {
+		a.Equal(".0", unexported.Loc)
+	}
+}
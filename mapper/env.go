@@ -0,0 +1,177 @@
+package mapper
+
+import (
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EnvMapper maps environment variables into a struct. The variable names
+// are matched case-insensitively after Prefix is stripped, and nested
+// structs are separated by underscores, e.g. APP_DB_HOST is mapped to the
+// field host of the field db with prefix APP_, or to the field db_host.
+// Slices are indexed from 0, e.g. APP_HOSTS_0, APP_HOSTS_1, and
+// APP_SERVERS_0_PORT for a slice of structs, until the first missing index
+type EnvMapper struct {
+	// Prefix is followed by an underscore in the variable names, so APP
+	// is the same as APP_, and doesn't match APPLE_X
+	Prefix string
+	// Mapper maps the collected values, and a default Mapper is used if nil
+	Mapper *Mapper
+}
+
+// EnvError indicates the value of an environment variable can't be parsed
+type EnvError struct {
+	Name string
+	Err  error
+}
+
+// Error implements error
+func (e *EnvError) Error() string {
+	return e.Name + ": " + e.Err.Error()
+}
+
+// Unwrap returns the parsing error
+func (e *EnvError) Unwrap() error {
+	return e.Err
+}
+
+// Map maps the environment variables of the process into v
+func (e *EnvMapper) Map(v interface{}) error {
+	return e.MapEnviron(v, os.Environ())
+}
+
+// MapEnviron maps the environment variables in the form of key=value into v
+func (e *EnvMapper) MapEnviron(v interface{}, environ []string) error {
+	m := e.Mapper
+	if m == nil {
+		m = &Mapper{}
+	}
+	prefix := strings.ToUpper(e.Prefix)
+	if prefix != "" && !strings.HasSuffix(prefix, "_") {
+		prefix += "_"
+	}
+	env := make(map[string]string)
+	for _, kv := range environ {
+		if pos := strings.Index(kv, "="); pos > 0 {
+			if key := strings.ToUpper(kv[:pos]); strings.HasPrefix(key, prefix) {
+				env[key] = kv[pos+1:]
+			}
+		}
+	}
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return nil
+	}
+	val, found, err := e.collect(m, t, strings.TrimSuffix(prefix, "_"), env)
+	if err != nil || !found {
+		return err
+	}
+	return m.Map(v, val)
+}
+
+func envName(name, key string) string {
+	if name == "" {
+		return strings.ToUpper(key)
+	}
+	return name + "_" + strings.ToUpper(key)
+}
+
+// hasEnvPrefix checks whether any variable is nested under name
+func hasEnvPrefix(name string, env map[string]string) bool {
+	if name == "" {
+		return len(env) > 0
+	}
+	for key := range env {
+		if strings.HasPrefix(key, name+"_") {
+			return true
+		}
+	}
+	return false
+}
+
+// collect builds the value of type t from the variables named by name.
+// Structs and slices are only descended into when some variables are
+// nested under name, which also ends the recursion of recursive types
+func (e *EnvMapper) collect(m *Mapper, t reflect.Type, name string, env map[string]string) (interface{}, bool, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t.Kind() == reflect.Struct && !isTextStruct(t):
+		if !hasEnvPrefix(name, env) {
+			return nil, false, nil
+		}
+		out := make(map[string]interface{})
+		if err := e.collectFields(m, t, name, env, out); err != nil {
+			return nil, false, err
+		}
+		return out, len(out) > 0, nil
+	case t.Kind() == reflect.Slice && !isByteSlice(t):
+		if !hasEnvPrefix(name, env) {
+			return nil, false, nil
+		}
+		var items []interface{}
+		for i := 0; ; i++ {
+			item, found, err := e.collect(m, t.Elem(), envName(name, strconv.Itoa(i)), env)
+			if err != nil {
+				return nil, false, err
+			}
+			if !found {
+				break
+			}
+			items = append(items, item)
+		}
+		return items, len(items) > 0, nil
+	}
+	str, found := env[name]
+	if !found {
+		return nil, false, nil
+	}
+	val, err := parseEnvValue(str, t)
+	if err != nil {
+		return nil, false, &EnvError{Name: name, Err: err}
+	}
+	return val, true, nil
+}
+
+func (e *EnvMapper) collectFields(m *Mapper, t reflect.Type, name string, env map[string]string, out map[string]interface{}) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		info := m.ParseField(field)
+		if (field.Anonymous || info.Squash) && field.Type.Kind() == reflect.Struct {
+			if err := e.collectFields(m, field.Type, name, env, out); err != nil {
+				return err
+			}
+			continue
+		}
+		if !info.Exported || info.Ignore || info.Wildcard || info.Pattern != "" || info.MapName == "" {
+			continue
+		}
+		val, found, err := e.collect(m, field.Type, envName(name, info.MapName), env)
+		if err != nil {
+			return err
+		}
+		if found {
+			out[info.MapName] = val
+		}
+	}
+	return nil
+}
+
+// parseEnvValue parses the string by the kind of the destination type,
+// and other types are left as strings
+func parseEnvValue(str string, t reflect.Type) (interface{}, error) {
+	switch TypeClass(t.Kind()) {
+	case BoolClass:
+		return strconv.ParseBool(str)
+	case IntClass:
+		return strconv.ParseInt(str, 0, t.Bits())
+	case UintClass:
+		return strconv.ParseUint(str, 0, t.Bits())
+	case FloatClass:
+		return strconv.ParseFloat(str, t.Bits())
+	}
+	return str, nil
+}
@@ -0,0 +1,346 @@
+package mapper
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// NotStructError indicates the value is not a struct
+type NotStructError struct {
+	Loc string
+}
+
+// Error implements error
+func (e *NotStructError) Error() string {
+	return fmt.Sprintf("not a struct [%s]", e.Loc)
+}
+
+// NotSettableError indicates the destination can't be set
+type NotSettableError struct {
+	Loc string
+}
+
+// Error implements error
+func (e *NotSettableError) Error() string {
+	return fmt.Sprintf("not allowed to set value [%s]", e.Loc)
+}
+
+// UnaddressableError indicates the destination passed to MapValue is
+// a value which can't be set, instead of a pointer
+type UnaddressableError struct {
+	Type reflect.Type
+}
+
+// Error implements error
+func (e *UnaddressableError) Error() string {
+	return fmt.Sprintf("destination of type %s is not addressable, pass a pointer like &v", e.Type)
+}
+
+// InvalidValueError indicates the destination is not a valid value
+type InvalidValueError struct {
+	Loc string
+}
+
+// Error implements error
+func (e *InvalidValueError) Error() string {
+	return fmt.Sprintf("invalid value [%s]", e.Loc)
+}
+
+// KeyTypeError indicates the map keys are not convertible
+type KeyTypeError struct {
+	Loc  string
+	From reflect.Type
+	To   reflect.Type
+}
+
+// Error implements error
+func (e *KeyTypeError) Error() string {
+	return fmt.Sprintf("map key type mismatch [%s]", e.Loc)
+}
+
+// ElemTypeError indicates the map elements are not convertible
+type ElemTypeError struct {
+	Loc  string
+	From reflect.Type
+	To   reflect.Type
+}
+
+// Error implements error
+func (e *ElemTypeError) Error() string {
+	return fmt.Sprintf("map element type mismatch [%s]", e.Loc)
+}
+
+// MismatchError indicates the source is not assignable to the destination
+type MismatchError struct {
+	Loc  string
+	From reflect.Type
+	To   reflect.Type
+}
+
+// Error implements error
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("unable to assign from type %s to %s [%s]",
+		kindOf(e.From), kindOf(e.To), e.Loc)
+}
+
+// kindOf returns the name of the kind of the type, which may be nil
+func kindOf(t reflect.Type) string {
+	if t == nil {
+		return reflect.Invalid.String()
+	}
+	return t.Kind().String()
+}
+
+// AsTypeError indicates the value can't be converted to the astype
+type AsTypeError struct {
+	Loc    string
+	AsType string
+	// Unsupported is true if the astype itself is not supported
+	Unsupported bool
+}
+
+// Error implements error
+func (e *AsTypeError) Error() string {
+	if e.Unsupported {
+		return fmt.Sprintf("unsupported astype %s [%s]", e.AsType, e.Loc)
+	}
+	return fmt.Sprintf("unable to convert to astype %s [%s]", e.AsType, e.Loc)
+}
+
+// ComplexError indicates the value is not a complex number in ComplexFormat
+type ComplexError struct {
+	Loc string
+}
+
+// Error implements error
+func (e *ComplexError) Error() string {
+	return fmt.Sprintf("invalid complex number [%s]", e.Loc)
+}
+
+// MergeConflictError indicates conflicting values with ErrorOnConflict
+type MergeConflictError struct {
+	Loc string
+}
+
+// Error implements error
+func (e *MergeConflictError) Error() string {
+	return fmt.Sprintf("merge conflict [%s]", e.Loc)
+}
+
+// HookError wraps the error returned by PreMapper, PostMapper,
+// MapDataLoader or a setter method
+type HookError struct {
+	Loc string
+	Err error
+}
+
+// Error implements error
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s [%s]", e.Err.Error(), e.Loc)
+}
+
+// Unwrap returns the error returned by the hook
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// MarshalError wraps the error returned by MarshalText or UnmarshalText,
+// or from parsing a string into time.Time
+type MarshalError struct {
+	Loc string
+	Err error
+}
+
+// Error implements error
+func (e *MarshalError) Error() string {
+	return fmt.Sprintf("%s [%s]", e.Err.Error(), e.Loc)
+}
+
+// Unwrap returns the error returned by MarshalText or UnmarshalText
+func (e *MarshalError) Unwrap() error {
+	return e.Err
+}
+
+// NonFiniteError indicates Inf or NaN is rejected by RejectNonFinite
+type NonFiniteError struct {
+	Loc   string
+	Value float64
+}
+
+// Error implements error
+func (e *NonFiniteError) Error() string {
+	return fmt.Sprintf("non-finite value %v [%s]", e.Value, e.Loc)
+}
+
+// UnexportedValueError indicates a value is obtained through unexported
+// fields, which can't be read by reflection
+type UnexportedValueError struct {
+	Loc  string
+	Type reflect.Type
+}
+
+// Error implements error
+func (e *UnexportedValueError) Error() string {
+	return fmt.Sprintf("unexported value of type %s [%s]", e.Type, e.Loc)
+}
+
+// BoolStringError indicates a string is not a recognized boolean
+type BoolStringError struct {
+	Loc   string
+	Value string
+}
+
+// Error implements error
+func (e *BoolStringError) Error() string {
+	return fmt.Sprintf("unrecognized boolean string %q [%s]", e.Value, e.Loc)
+}
+
+// ParseNumberError indicates a string is not a valid number of type To
+type ParseNumberError struct {
+	Loc   string
+	Value string
+	To    reflect.Type
+}
+
+// Error implements error
+func (e *ParseNumberError) Error() string {
+	return fmt.Sprintf("unable to parse %q as %s [%s]", e.Value, e.To, e.Loc)
+}
+
+// TimeoutError indicates the mapping is aborted by MapTimeout
+type TimeoutError struct {
+	Loc     string
+	Timeout time.Duration
+}
+
+// Error implements error
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("mapping timed out after %s [%s]", e.Timeout, e.Loc)
+}
+
+// InvalidKeyError indicates a map key is rejected by KeyValidators,
+// or is not an index with NumericMapToSlice
+type InvalidKeyError struct {
+	Loc string
+	Key string
+}
+
+// Error implements error
+func (e *InvalidKeyError) Error() string {
+	return fmt.Sprintf("invalid key %q [%s]", e.Key, e.Loc)
+}
+
+// ScanCountError indicates the number of destinations passed to Scan
+// doesn't match the number of source values
+type ScanCountError struct {
+	Values int
+	Dests  int
+}
+
+// Error implements error
+func (e *ScanCountError) Error() string {
+	return fmt.Sprintf("scan %d values into %d destinations", e.Values, e.Dests)
+}
+
+// DecodeBytesError indicates a string is not valid in ByteSliceFormat
+type DecodeBytesError struct {
+	Loc string
+	Err error
+}
+
+// Error implements error
+func (e *DecodeBytesError) Error() string {
+	return fmt.Sprintf("%s [%s]", e.Err.Error(), e.Loc)
+}
+
+// Unwrap returns the decoding error
+func (e *DecodeBytesError) Unwrap() error {
+	return e.Err
+}
+
+// PanicError is a panic recovered by RecoverPanics
+type PanicError struct {
+	Loc   string
+	Value interface{}
+}
+
+// Error implements error
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v [%s]", e.Value, e.Loc)
+}
+
+// Unwrap returns the panic value if it's an error, e.g. runtime.Error
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+var errConverterNotFound = errors.New("converter not registered")
+
+// ConverterError wraps the error from the FieldConverter of the name,
+// or indicates the converter is not registered
+type ConverterError struct {
+	Loc  string
+	Name string
+	Err  error
+}
+
+// Error implements error
+func (e *ConverterError) Error() string {
+	return fmt.Sprintf("converter %s: %s [%s]", e.Name, e.Err.Error(), e.Loc)
+}
+
+// Unwrap returns the error from the converter
+func (e *ConverterError) Unwrap() error {
+	return e.Err
+}
+
+// FuncSignatureError indicates a function is assigned to a function
+// destination of a different signature
+type FuncSignatureError struct {
+	Loc  string
+	From reflect.Type
+	To   reflect.Type
+}
+
+// Error implements error
+func (e *FuncSignatureError) Error() string {
+	return fmt.Sprintf("function signature mismatch: %s is not %s [%s]", e.From, e.To, e.Loc)
+}
+
+// ChannelFullError indicates an element can't be sent into a channel
+// with ChannelIO without blocking
+type ChannelFullError struct {
+	Loc string
+	Cap int
+}
+
+// Error implements error
+func (e *ChannelFullError) Error() string {
+	return fmt.Sprintf("channel full with capacity %d [%s]", e.Cap, e.Loc)
+}
+
+// FloatToIntError indicates a float can't be mapped to the integer type
+// by Mapper.FloatToInt, as it overflows or has a fraction
+type FloatToIntError struct {
+	Loc   string
+	Value float64
+	Type  reflect.Type
+}
+
+// Error implements error
+func (e *FloatToIntError) Error() string {
+	return fmt.Sprintf("float %v can't be converted to %s [%s]", e.Value, e.Type, e.Loc)
+}
+
+// UnknownDecoderError indicates no decoder is registered with the name
+type UnknownDecoderError struct {
+	Name string
+}
+
+// Error implements error
+func (e *UnknownDecoderError) Error() string {
+	return fmt.Sprintf("unknown decoder %q", e.Name)
+}
@@ -1,12 +1,21 @@
 package mapper
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
 
 	yaml "gopkg.in/yaml.v2"
 )
@@ -14,7 +23,97 @@ import (
 // Loader loads and parses map from file/string
 type Loader struct {
 	Map     map[string]interface{}
+	Slice   []interface{}
 	Decoder Decoder
+	// NoDecompress disables auto-detection of gzip compressed streams
+	NoDecompress bool
+	// OptionalFiles lets LoadFiles skip the files which don't exist
+	OptionalFiles bool
+	// TemplateFuncs are the functions available to LoadTemplate
+	TemplateFuncs template.FuncMap
+	// MaxBytes limits the size of content read by LoadStream, including
+	// the decompressed size, and zero means unlimited
+	MaxBytes int64
+}
+
+// SizeLimitError indicates the content exceeds Loader.MaxBytes
+type SizeLimitError struct {
+	MaxBytes int64
+}
+
+// Error implements error
+func (e *SizeLimitError) Error() string {
+	return fmt.Sprintf("content exceeds %d bytes", e.MaxBytes)
+}
+
+// DepthLimitError indicates the decoded content is nested deeper than
+// MaxDepth of the decoder
+type DepthLimitError struct {
+	MaxDepth int
+}
+
+// Error implements error
+func (e *DepthLimitError) Error() string {
+	return fmt.Sprintf("content nested deeper than %d levels", e.MaxDepth)
+}
+
+// DecompressError indicates the content failed to decompress
+type DecompressError struct {
+	Err error
+}
+
+// Error implements error
+func (e *DecompressError) Error() string {
+	return "decompress: " + e.Err.Error()
+}
+
+// Unwrap returns the error from the decompression
+func (e *DecompressError) Unwrap() error {
+	return e.Err
+}
+
+// DuplicateKeyError indicates a key appears more than once in an object
+type DuplicateKeyError struct {
+	// Path is the dotted path of the duplicated key
+	Path string
+}
+
+// Error implements error
+func (e *DuplicateKeyError) Error() string {
+	return "duplicate key [" + e.Path + "]"
+}
+
+// ContentTypeError indicates the decoded content is not a map or slice,
+// or not a map where only maps can be merged
+type ContentTypeError struct {
+	// File is the name of the file if the content is loaded from a file
+	File string
+	// Expected describes the accepted types, e.g. "map or slice"
+	Expected string
+}
+
+// Error implements error
+func (e *ContentTypeError) Error() string {
+	msg := "content is not a " + e.Expected
+	if e.File != "" {
+		msg += " [" + e.File + "]"
+	}
+	return msg
+}
+
+// TemplateError indicates the content failed to render as a template
+type TemplateError struct {
+	Err error
+}
+
+// Error implements error
+func (e *TemplateError) Error() string {
+	return "render: " + e.Err.Error()
+}
+
+// Unwrap returns the error from parsing or executing the template
+func (e *TemplateError) Unwrap() error {
+	return e.Err
 }
 
 // Decoder defines the interface for parsing the content
@@ -22,6 +121,55 @@ type Decoder interface {
 	Decode(content []byte) (interface{}, error)
 }
 
+var (
+	decoders = map[string]Decoder{
+		"json": &JSONDecoder{},
+		"yaml": &YAMLDecoder{},
+		"auto": &AutoDecoder{},
+	}
+	decodersLock sync.RWMutex
+)
+
+// RegisterDecoder registers the decoder by name for Loader.UseDecoder and
+// Loader.LoadBytesWithType, and replaces the decoder already registered
+// with the name, or removes it if d is nil.
+// The built-in names are json, yaml and auto
+func RegisterDecoder(name string, d Decoder) {
+	decodersLock.Lock()
+	defer decodersLock.Unlock()
+	if d == nil {
+		delete(decoders, name)
+	} else {
+		decoders[name] = d
+	}
+}
+
+// UseDecoder sets the Decoder registered by name. A decoder which is
+// a pointer to a struct is copied, so its options can be changed for
+// the Loader, otherwise the registered decoder is shared by Loaders
+func (l *Loader) UseDecoder(name string) error {
+	decodersLock.RLock()
+	d, ok := decoders[name]
+	decodersLock.RUnlock()
+	if !ok {
+		return &UnknownDecoderError{Name: name}
+	}
+	l.Decoder = copyDecoder(d)
+	return nil
+}
+
+// copyDecoder returns a shallow copy of a decoder which is a pointer to
+// a struct, or the decoder itself
+func copyDecoder(d Decoder) Decoder {
+	v := reflect.ValueOf(d)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return d
+	}
+	c := reflect.New(v.Elem().Type())
+	c.Elem().Set(v.Elem())
+	return c.Interface().(Decoder)
+}
+
 // LoadString decodes the content in string
 func (l *Loader) LoadString(content string) error {
 	return l.LoadBytes([]byte(content))
@@ -33,22 +181,139 @@ func (l *Loader) LoadBytes(content []byte) error {
 	if decoder == nil {
 		decoder = &AutoDecoder{}
 	}
+	return l.loadBytes(content, decoder)
+}
+
+// LoadTemplate renders the content as a text/template with data and
+// TemplateFuncs, and decodes the rendered content
+func (l *Loader) LoadTemplate(content []byte, data interface{}) error {
+	tmpl, err := template.New("content").Funcs(l.TemplateFuncs).Parse(string(content))
+	if err != nil {
+		return &TemplateError{Err: err}
+	}
+	var rendered bytes.Buffer
+	if err = tmpl.Execute(&rendered, data); err != nil {
+		return &TemplateError{Err: err}
+	}
+	return l.LoadBytes(rendered.Bytes())
+}
+
+// LoadBytesWithType decodes the content with the decoder selected by
+// the MIME type, e.g. from the Content-Type header. The format is the
+// suffix like +json or +yaml, or the subtype without the x- prefix, e.g.
+// toml of application/toml. JSON and YAML are decoded with the options of
+// Decoder if it's a JSONDecoder, YAMLDecoder or AutoDecoder, and other
+// formats with the decoder registered by the name of the format.
+// Content of unknown formats is decoded by Decoder, or AutoDecoder
+func (l *Loader) LoadBytesWithType(content []byte, contentType string) error {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return err
+	}
+	return l.loadBytes(content, l.decoderOfFormat(formatOfType(mediaType)))
+}
+
+// formatOfType returns the name of the format of the media type
+func formatOfType(mediaType string) string {
+	format := mediaType
+	if n := strings.LastIndexByte(format, '+'); n >= 0 {
+		format = format[n+1:]
+	} else if n = strings.IndexByte(format, '/'); n >= 0 {
+		format = format[n+1:]
+	}
+	format = strings.TrimPrefix(format, "x-")
+	if format == "yml" {
+		format = "yaml"
+	}
+	return format
+}
+
+// decoderOfFormat selects the decoder of the format, or the default one
+// if the format is unknown
+func (l *Loader) decoderOfFormat(format string) Decoder {
+	switch d := l.Decoder.(type) {
+	case *JSONDecoder:
+		if format == "json" {
+			return d
+		}
+	case *YAMLDecoder:
+		if format == "yaml" {
+			return d
+		}
+	case *AutoDecoder:
+		if format == "json" {
+			return d.jsonDecoder()
+		} else if format == "yaml" {
+			return d.yamlDecoder()
+		}
+	}
+	decodersLock.RLock()
+	d, ok := decoders[format]
+	decodersLock.RUnlock()
+	if ok {
+		return d
+	}
+	if l.Decoder != nil {
+		return l.Decoder
+	}
+	return &AutoDecoder{}
+}
+
+func (l *Loader) loadBytes(content []byte, decoder Decoder) error {
 	d, err := decoder.Decode(content)
 	if err == nil {
-		if m, ok := d.(map[string]interface{}); ok {
-			l.Map = m
-		} else {
-			err = fmt.Errorf("content is not a map")
+		switch v := d.(type) {
+		case map[string]interface{}:
+			l.Map, l.Slice = v, nil
+		case []interface{}:
+			l.Map, l.Slice = nil, v
+		default:
+			err = &ContentTypeError{Expected: "map or slice"}
 		}
 	}
 	return err
 }
 
 // LoadStream loads from a stream
+// gzip compressed content is decompressed unless NoDecompress is set
 func (l *Loader) LoadStream(s io.Reader) error {
-	content, err := ioutil.ReadAll(s)
+	if !l.NoDecompress {
+		r := bufio.NewReader(s)
+		if magic, err := r.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+			return l.loadCompressedStream(r)
+		}
+		s = r
+	}
+	content, err := l.readAll(s)
+	if err != nil {
+		return err
+	}
+	return l.LoadBytes(content)
+}
+
+// readAll reads no more than MaxBytes
+func (l *Loader) readAll(r io.Reader) ([]byte, error) {
+	if l.MaxBytes <= 0 {
+		return ioutil.ReadAll(r)
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(r, l.MaxBytes+1))
+	if err == nil && int64(len(content)) > l.MaxBytes {
+		err = &SizeLimitError{MaxBytes: l.MaxBytes}
+	}
+	return content, err
+}
+
+func (l *Loader) loadCompressedStream(s io.Reader) error {
+	r, err := gzip.NewReader(s)
 	if err != nil {
+		return &DecompressError{Err: err}
+	}
+	defer r.Close()
+	content, err := l.readAll(r)
+	if _, ok := err.(*SizeLimitError); ok {
 		return err
+	} else if err != nil {
+		return &DecompressError{Err: err}
 	}
 	return l.LoadBytes(content)
 }
@@ -63,58 +328,343 @@ func (l *Loader) LoadFile(fn string) error {
 		return err
 	}
 	defer f.Close()
-	return l.LoadStream(f)
+	err = l.LoadStream(f)
+	if typeErr, ok := err.(*ContentTypeError); ok {
+		typeErr.File = fn
+	}
+	return err
+}
+
+// LoadFiles loads the files in order and deep merges later files over
+// earlier ones. Maps are merged recursively, while slices and other
+// values in later files replace the ones in earlier files
+func (l *Loader) LoadFiles(fns ...string) error {
+	var merged map[string]interface{}
+	for _, fn := range fns {
+		layer := &Loader{Decoder: l.Decoder, NoDecompress: l.NoDecompress, MaxBytes: l.MaxBytes}
+		if err := layer.LoadFile(fn); err != nil {
+			if l.OptionalFiles && os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if layer.Map == nil {
+			return &ContentTypeError{File: fn, Expected: "map"}
+		}
+		if merged == nil {
+			merged = layer.Map
+		} else if err := Map(merged, layer.Map); err != nil {
+			return err
+		}
+	}
+	if merged != nil {
+		l.Map, l.Slice = merged, nil
+	}
+	return nil
 }
 
 // Loaded determines if content has been loaded
 func (l *Loader) Loaded() bool {
-	return l.Map != nil
+	return l.Map != nil || l.Slice != nil
 }
 
 // As maps the decoded content into specific type
+// A top-level slice is mapped when out is a slice destination.
+// A map is mapped into a slice destination as a named list: each entry
+// becomes an element in the order of sorted keys, and the key is
+// injected into the fields tagged with key
 func (l *Loader) As(out interface{}) error {
-	if l.Loaded() {
+	if l.Map != nil {
+		if t := reflect.TypeOf(out); t != nil && t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Slice {
+			return l.asNamedList(reflect.ValueOf(out).Elem())
+		}
 		return Map(out, l.Map)
 	}
+	if l.Slice != nil {
+		return Map(out, l.Slice)
+	}
+	return nil
+}
+
+// SubPathError indicates the path of Loader.Sub doesn't resolve to a map
+type SubPathError struct {
+	Path string
+}
+
+// Error implements error
+func (e *SubPathError) Error() string {
+	return "not a map [" + e.Path + "]"
+}
+
+// Sub returns a new Loader rooted at the map of the dotted path of keys,
+// e.g. "server.tls", and numeric segments index into slices, e.g.
+// "servers.0", and dots in keys are escaped like a\.b. The map is shared
+// with the original Loader
+func (l *Loader) Sub(path string) (*Loader, error) {
+	var cur interface{} = l.Map
+	if l.Map == nil && l.Slice != nil {
+		cur = l.Slice
+	}
+	if path != "" {
+		for _, key := range SplitPath(path) {
+			switch val := cur.(type) {
+			case map[string]interface{}:
+				cur = val[key]
+			case []interface{}:
+				n, err := strconv.Atoi(key)
+				if err != nil || n < 0 || n >= len(val) {
+					return nil, &SubPathError{Path: path}
+				}
+				cur = val[n]
+			default:
+				return nil, &SubPathError{Path: path}
+			}
+		}
+	}
+	sub, ok := cur.(map[string]interface{})
+	if !ok || sub == nil {
+		return nil, &SubPathError{Path: path}
+	}
+	return &Loader{
+		Map:           sub,
+		Decoder:       l.Decoder,
+		NoDecompress:  l.NoDecompress,
+		OptionalFiles: l.OptionalFiles,
+		TemplateFuncs: l.TemplateFuncs,
+		MaxBytes:      l.MaxBytes,
+	}, nil
+}
+
+func (l *Loader) asNamedList(out reflect.Value) error {
+	keys := make([]string, 0, len(l.Map))
+	for key := range l.Map {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	items := make([]interface{}, len(keys))
+	for n, key := range keys {
+		items[n] = l.Map[key]
+	}
+	m := &Mapper{}
+	if err := m.MapValue(out, reflect.ValueOf(items)); err != nil {
+		return err
+	}
+	for n, key := range keys {
+		if err := m.assignKeyFields(out.Index(n), reflect.ValueOf(key), m.locExp("", strconv.Itoa(n))); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 // JSONDecoder decodes content in JSON
 type JSONDecoder struct {
+	// RejectDuplicateKeys fails decoding if a key appears more than
+	// once in the same object, instead of taking the last value
+	RejectDuplicateKeys bool
+	// MaxDepth limits the nesting levels of objects and arrays,
+	// and zero means unlimited
+	MaxDepth int
 }
 
-// Decode implements Decoder
+// Decode implements Decoder. The tokens are scanned for the duplicate
+// keys and the depth before the content is decoded
 func (d *JSONDecoder) Decode(content []byte) (out interface{}, err error) {
-	out = make(map[string]interface{})
-	err = json.Unmarshal(content, out)
+	if d.RejectDuplicateKeys || d.MaxDepth > 0 {
+		scanner := &jsonScanner{
+			dec:                 json.NewDecoder(bytes.NewReader(content)),
+			rejectDuplicateKeys: d.RejectDuplicateKeys,
+			maxDepth:            d.MaxDepth,
+		}
+		if err = scanner.scan("", 0); err != nil {
+			return nil, err
+		}
+	}
+	err = json.Unmarshal(content, &out)
 	return
 }
 
-// YAMLDecoder decodes content in YAML
+// jsonScanner checks the tokens of JSON content against the options
+// of JSONDecoder
+type jsonScanner struct {
+	dec                 *json.Decoder
+	rejectDuplicateKeys bool
+	maxDepth            int
+}
+
+// scan checks the tokens of a single JSON value at the path, under depth
+// levels of objects and arrays
+func (s *jsonScanner) scan(path string, depth int) error {
+	tok, err := s.dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == json.Delim('{') || tok == json.Delim('[') {
+		if depth++; s.maxDepth > 0 && depth > s.maxDepth {
+			return &DepthLimitError{MaxDepth: s.maxDepth}
+		}
+	}
+	switch tok {
+	case json.Delim('{'):
+		keys := make(map[string]bool)
+		for s.dec.More() {
+			tok, err = s.dec.Token()
+			if err != nil {
+				return err
+			}
+			key := tok.(string)
+			keyPath := joinPath(path, key)
+			if s.rejectDuplicateKeys && keys[key] {
+				return &DuplicateKeyError{Path: keyPath}
+			}
+			keys[key] = true
+			if err = s.scan(keyPath, depth); err != nil {
+				return err
+			}
+		}
+		_, err = s.dec.Token()
+	case json.Delim('['):
+		for n := 0; s.dec.More(); n++ {
+			if err = s.scan(joinPath(path, strconv.Itoa(n)), depth); err != nil {
+				return err
+			}
+		}
+		_, err = s.dec.Token()
+	}
+	return err
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return EscapePathKey(key)
+	}
+	return path + "." + EscapePathKey(key)
+}
+
+// YAMLDecoder decodes content in YAML. yaml.v2 parses the whole content
+// before decoding, so the nesting depth can't be limited while decoding,
+// and yamlv3.Decoder provides MaxDepth instead
 type YAMLDecoder struct {
+	// ForceStringKeys lists keys whose scalar values are kept as the
+	// literal text, e.g. version: 1.10 is decoded as "1.10", not 1.1
+	ForceStringKeys []string
+	// StrictKeys fails decoding if distinct keys are converted to the
+	// same string key, e.g. true and "true"
+	StrictKeys bool
+}
+
+// yamlValue captures both the decoded value and the literal text
+type yamlValue struct {
+	val interface{}
+	raw *string
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (v *yamlValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&v.val); err != nil {
+		return err
+	}
+	switch v.val.(type) {
+	case nil:
+	case []interface{}:
+		var items []*yamlValue
+		if err := unmarshal(&items); err != nil {
+			return err
+		}
+		v.val = items
+	case map[interface{}]interface{}:
+		var m map[interface{}]*yamlValue
+		if err := unmarshal(&m); err != nil {
+			return err
+		}
+		v.val = m
+	default:
+		var raw string
+		if unmarshal(&raw) == nil {
+			v.raw = &raw
+		}
+	}
+	return nil
+}
+
+func (v *yamlValue) value(forceString map[string]bool, asString bool) interface{} {
+	if v == nil {
+		return nil
+	}
+	switch val := v.val.(type) {
+	case []*yamlValue:
+		items := make([]interface{}, len(val))
+		for n, item := range val {
+			items[n] = item.value(forceString, false)
+		}
+		return items
+	case map[interface{}]*yamlValue:
+		m := make(map[interface{}]interface{})
+		for key, item := range val {
+			m[key] = item.value(forceString, forceString[fmt.Sprintf("%v", key)])
+		}
+		return m
+	}
+	if asString && v.raw != nil {
+		return *v.raw
+	}
+	return v.val
 }
 
 // Decode implements Decoder
 func (d *YAMLDecoder) Decode(content []byte) (out interface{}, err error) {
-	out = make(map[string]interface{})
-	err = yaml.Unmarshal(content, out)
+	if len(d.ForceStringKeys) > 0 {
+		v := &yamlValue{}
+		if err = yaml.Unmarshal(content, v); err == nil {
+			forceString := make(map[string]bool)
+			for _, key := range d.ForceStringKeys {
+				forceString[key] = true
+			}
+			out = v.value(forceString, false)
+		}
+	} else {
+		err = yaml.Unmarshal(content, &out)
+	}
 	if err == nil {
-		out = StringifyKeys(out)
+		if out == nil {
+			out = make(map[string]interface{})
+		}
+		if d.StrictKeys {
+			out, err = StringifyKeysStrict(out)
+		} else {
+			out = StringifyKeys(out)
+		}
 	}
 	return
 }
 
 // AutoDecoder selects the correct decoder by detecting the content
 type AutoDecoder struct {
+	// JSON and YAML are the decoders with options for the detected
+	// content, and decoders without options are used if nil
+	JSON *JSONDecoder
+	YAML *YAMLDecoder
 }
 
 // Decode implements Decoder
 func (d *AutoDecoder) Decode(content []byte) (out interface{}, err error) {
-	var decoder Decoder
-	if bytes.HasPrefix(bytes.TrimSpace(content), []byte{'{'}) {
-		decoder = &JSONDecoder{}
-	} else {
-		decoder = &YAMLDecoder{}
+	trimmed := bytes.TrimSpace(content)
+	if bytes.HasPrefix(trimmed, []byte{'{'}) || bytes.HasPrefix(trimmed, []byte{'['}) {
+		return d.jsonDecoder().Decode(content)
+	}
+	return d.yamlDecoder().Decode(content)
+}
+
+func (d *AutoDecoder) jsonDecoder() *JSONDecoder {
+	if d.JSON != nil {
+		return d.JSON
+	}
+	return &JSONDecoder{}
+}
+
+func (d *AutoDecoder) yamlDecoder() *YAMLDecoder {
+	if d.YAML != nil {
+		return d.YAML
 	}
-	return decoder.Decode(content)
+	return &YAMLDecoder{}
 }
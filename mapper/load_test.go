@@ -0,0 +1,488 @@
+package mapper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type loadedServer struct {
+	Name string `map:"name,key"`
+	Host string `map:"host"`
+	Port int    `map:"port"`
+}
+
+func TestLoaderAs(t *testing.T) {
+	a := assert.New(t)
+	l := &Loader{}
+	var s loadedServer
+	a.NoError(l.As(&s), "nothing loaded")
+	if a.NoError(l.LoadString("host: localhost\nport: 80\n")) && a.NoError(l.As(&s)) {
+		a.Equal(loadedServer{Host: "localhost", Port: 80}, s)
+	}
+	var list []loadedServer
+	if a.NoError(l.LoadString("- host: a\n- host: b\n  port: 81\n")) && a.NoError(l.As(&list)) {
+		a.Equal([]loadedServer{{Host: "a"}, {Host: "b", Port: 81}}, list)
+	}
+	a.Error(l.As(&s), "a slice is not mapped into a struct")
+}
+
+func gzipped(content string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(content))
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestLoadStreamGzip(t *testing.T) {
+	a := assert.New(t)
+	l := &Loader{}
+	if a.NoError(l.LoadStream(bytes.NewReader(gzipped(`{"a": 1}`)))) {
+		a.Equal(map[string]interface{}{"a": 1.0}, l.Map)
+	}
+
+	var decompressErr *DecompressError
+	corrupt := gzipped(`{"a": 1}`)
+	corrupt[len(corrupt)-5] ^= 0xff
+	err := l.LoadStream(bytes.NewReader(corrupt))
+	a.True(errors.As(err, &decompressErr))
+	a.True(errors.Is(err, gzip.ErrChecksum))
+	a.True(errors.As(l.LoadStream(bytes.NewReader([]byte{0x1f, 0x8b, 0})), &decompressErr))
+
+	l.NoDecompress = true
+	err = l.LoadStream(bytes.NewReader(gzipped(`{"a": 1}`)))
+	a.Error(err)
+	a.False(errors.As(err, &decompressErr))
+}
+
+func TestYAMLDecoderForceStringKeys(t *testing.T) {
+	a := assert.New(t)
+	content := `
+version: 1.10
+1: 1.20
+true: 010
+count: 1.10
+nested:
+  version: 2.0
+  other: 2.0
+items:
+  - version: 3.10
+    count: 3.10
+  - {version: 4.0}
+versions: [1.10, 2.0]
+`
+	out, err := (&YAMLDecoder{ForceStringKeys: []string{"version", "1", "true", "versions"}}).Decode([]byte(content))
+	if a.NoError(err) {
+		a.Equal(map[string]interface{}{
+			"version": "1.10",
+			"1":       "1.20",
+			"true":    "010",
+			"count":   1.1,
+			"nested":  map[string]interface{}{"version": "2.0", "other": 2.0},
+			"items": []interface{}{
+				map[string]interface{}{"version": "3.10", "count": 3.1},
+				map[string]interface{}{"version": "4.0"},
+			},
+			// only scalar values are kept as the literal text
+			"versions": []interface{}{1.1, 2.0},
+		}, out)
+	}
+	out, err = (&YAMLDecoder{}).Decode([]byte(content))
+	if a.NoError(err) {
+		a.Equal(1.1, out.(map[string]interface{})["version"])
+		a.Equal(8, out.(map[string]interface{})["true"])
+	}
+}
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "mapper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadFiles(t *testing.T) {
+	a := assert.New(t)
+	dir := writeFiles(t, map[string]string{
+		"base.yaml":     "name: base\nserver:\n  host: localhost\n  port: 80\ntags: [a, b]\n",
+		"override.json": `{"server": {"port": 8080}, "tags": ["c"]}`,
+		"last.yaml":     "name: last\n",
+		"list.yaml":     "- a\n",
+		"scalar.yaml":   "1\n",
+	})
+	defer os.RemoveAll(dir)
+	l := &Loader{}
+	if a.NoError(l.LoadFiles(filepath.Join(dir, "base.yaml"), filepath.Join(dir, "override.json"), filepath.Join(dir, "last.yaml"))) {
+		a.Equal(map[string]interface{}{
+			"name":   "last",
+			"server": map[string]interface{}{"host": "localhost", "port": 8080.0},
+			"tags":   []interface{}{"c"},
+		}, l.Map)
+	}
+	// the order decides which value wins
+	if a.NoError(l.LoadFiles(filepath.Join(dir, "last.yaml"), filepath.Join(dir, "base.yaml"))) {
+		a.Equal("base", l.Map["name"])
+	}
+
+	missing := filepath.Join(dir, "missing.yaml")
+	err := l.LoadFiles(filepath.Join(dir, "base.yaml"), missing)
+	a.True(os.IsNotExist(err))
+	l = &Loader{OptionalFiles: true}
+	if a.NoError(l.LoadFiles(missing, filepath.Join(dir, "base.yaml"), missing)) {
+		a.Equal("base", l.Map["name"])
+	}
+	l = &Loader{OptionalFiles: true}
+	if a.NoError(l.LoadFiles(missing)) {
+		a.False(l.Loaded())
+	}
+	var typeErr *ContentTypeError
+	list := filepath.Join(dir, "list.yaml")
+	if a.True(errors.As(l.LoadFiles(list), &typeErr), "a slice is not merged") {
+		a.Equal(list, typeErr.File)
+		a.Equal("map", typeErr.Expected)
+	}
+	if a.True(errors.As(l.LoadFile(filepath.Join(dir, "scalar.yaml")), &typeErr)) {
+		a.Equal(filepath.Join(dir, "scalar.yaml"), typeErr.File)
+		a.Equal("map or slice", typeErr.Expected)
+	}
+	if a.True(errors.As(l.LoadString("1"), &typeErr)) {
+		a.Empty(typeErr.File)
+	}
+}
+
+func TestJSONDecoderRejectDuplicateKeys(t *testing.T) {
+	a := assert.New(t)
+	d := &JSONDecoder{}
+	out, err := d.Decode([]byte(`{"a": 1, "a": 2}`))
+	if a.NoError(err) {
+		a.Equal(map[string]interface{}{"a": 2.0}, out)
+	}
+	d.RejectDuplicateKeys = true
+	_, err = d.Decode([]byte(`{"a": {"b": 1}, "c": [{"b": 1}, {"b": 2}], "d": {"b": 1}}`))
+	a.NoError(err)
+	var dupErr *DuplicateKeyError
+	for content, path := range map[string]string{
+		`{"a": 1, "a": 2}`:                       "a",
+		`{"a": {"b": 1, "c": {"d": 1, "d": 2}}}`: "a.c.d",
+		`{"a": [{"b": 1}, {"b": 1, "b": 2}]}`:    "a.1.b",
+		`[[{"x": 1}], [{"x": 1, "x": 1}]]`:       "1.0.x",
+		`{"a.b": {"c": 1, "c": 2}}`:              `a\.b.c`,
+	} {
+		_, err = d.Decode([]byte(content))
+		if a.True(errors.As(err, &dupErr), content) {
+			a.Equal(path, dupErr.Path, content)
+		}
+	}
+}
+
+func TestLoaderAsNamedList(t *testing.T) {
+	a := assert.New(t)
+	l := &Loader{}
+	content := `
+web:
+  host: w
+  port: 80
+db:
+  host: d
+  port: 5432
+`
+	var list []loadedServer
+	if a.NoError(l.LoadString(content)) && a.NoError(l.As(&list)) {
+		a.Equal([]loadedServer{
+			{Name: "db", Host: "d", Port: 5432},
+			{Name: "web", Host: "w", Port: 80},
+		}, list)
+	}
+	var ptrs []*loadedServer
+	if a.NoError(l.As(&ptrs)) && a.Len(ptrs, 2) {
+		a.Equal("db", ptrs[0].Name)
+		a.Equal("web", ptrs[1].Name)
+	}
+	if a.NoError(l.LoadString("a: 1")) {
+		a.Error(l.As(&list), "scalar entries are not structs")
+	}
+}
+
+func TestStringifyKeysStrict(t *testing.T) {
+	a := assert.New(t)
+	var collision *KeyCollisionError
+	_, err := StringifyKeysStrict(map[interface{}]interface{}{1: "a", "1": "b"})
+	if a.True(errors.As(err, &collision)) {
+		a.Equal("1", collision.Key)
+	}
+	in := []interface{}{map[string]interface{}{
+		"a.b": map[interface{}]interface{}{true: 1, "true": 2},
+		"c":   []interface{}{map[interface{}]interface{}{1: 1}},
+	}}
+	_, err = StringifyKeysStrict(in)
+	if a.True(errors.As(err, &collision)) {
+		a.Equal("true", collision.Key)
+		a.Equal(`0.a\.b.true`, collision.Path)
+	}
+	// the input is left unchanged
+	a.Equal([]interface{}{map[string]interface{}{
+		"a.b": map[interface{}]interface{}{true: 1, "true": 2},
+		"c":   []interface{}{map[interface{}]interface{}{1: 1}},
+	}}, in)
+	out, err := StringifyKeysStrict(map[interface{}]interface{}{1: "a", "2": []interface{}{map[interface{}]interface{}{true: 1}}})
+	if a.NoError(err) {
+		a.Equal(map[string]interface{}{
+			"1": "a",
+			"2": []interface{}{map[string]interface{}{"true": 1}},
+		}, out)
+	}
+
+	content := "1: a\n\"1\": b\n"
+	out, err = (&YAMLDecoder{}).Decode([]byte(content))
+	if a.NoError(err) {
+		a.Len(out, 1)
+	}
+	_, err = (&YAMLDecoder{StrictKeys: true}).Decode([]byte(content))
+	if a.True(errors.As(err, &collision)) {
+		a.Equal("1", collision.Key)
+	}
+}
+
+func TestLoadTemplate(t *testing.T) {
+	a := assert.New(t)
+	l := &Loader{TemplateFuncs: template.FuncMap{"upper": strings.ToUpper}}
+	data := map[string]interface{}{"Env": map[string]string{"HOME": "/home/user"}}
+	content := "home: {{ .Env.HOME }}\nname: {{ upper \"app\" }}\n"
+	if a.NoError(l.LoadTemplate([]byte(content), data)) {
+		a.Equal(map[string]interface{}{"home": "/home/user", "name": "APP"}, l.Map)
+	}
+
+	var tmplErr *TemplateError
+	a.True(errors.As(l.LoadTemplate([]byte("name: {{ unknown }}"), data), &tmplErr), "parse error")
+	errFailed := errors.New("failed")
+	l.TemplateFuncs["fail"] = func() (string, error) { return "", errFailed }
+	err := l.LoadTemplate([]byte("name: {{ fail }}"), data)
+	if a.True(errors.As(err, &tmplErr), "execution error") {
+		a.Contains(err.Error(), "failed")
+		var execErr template.ExecError
+		a.True(errors.As(err, &execErr))
+		a.True(errors.Is(err, errFailed))
+	}
+	err = l.LoadTemplate([]byte("{{ .Env.HOME }}: ["), data)
+	a.Error(err)
+	a.False(errors.As(err, &tmplErr), "decode errors are not render errors")
+}
+
+func TestLoaderSub(t *testing.T) {
+	a := assert.New(t)
+	l := &Loader{MaxBytes: 10}
+	content := `
+server:
+  tls:
+    cert: c
+  name: s
+servers:
+  - host: a
+  - host: b
+a.b:
+  c: 1
+`
+	if !a.NoError(l.LoadString(content)) {
+		return
+	}
+	sub, err := l.Sub("server.tls")
+	if a.NoError(err) {
+		a.Equal(map[string]interface{}{"cert": "c"}, sub.Map)
+		a.EqualValues(10, sub.MaxBytes)
+		sub.Map["key"] = "k"
+		a.Equal("k", l.Map["server"].(map[string]interface{})["tls"].(map[string]interface{})["key"])
+	}
+	if sub, err = l.Sub("servers.1"); a.NoError(err) {
+		a.Equal(map[string]interface{}{"host": "b"}, sub.Map)
+	}
+	if sub, err = l.Sub(`a\.b`); a.NoError(err) {
+		a.Equal(map[string]interface{}{"c": 1}, sub.Map)
+	}
+	if sub, err = l.Sub(""); a.NoError(err) {
+		a.Equal(l.Map, sub.Map)
+	}
+
+	var pathErr *SubPathError
+	for _, path := range []string{
+		"missing",
+		"server.missing.cert",
+		"server.name",
+		"server.name.x",
+		"servers",
+		"servers.2",
+		"servers.x",
+		"a.b",
+	} {
+		_, err = l.Sub(path)
+		if a.True(errors.As(err, &pathErr), path) {
+			a.Equal(path, pathErr.Path)
+		}
+	}
+	_, err = (&Loader{}).Sub("")
+	a.True(errors.As(err, &pathErr), "nothing loaded")
+}
+
+func TestLoaderMaxBytes(t *testing.T) {
+	a := assert.New(t)
+	l := &Loader{MaxBytes: 8}
+	if a.NoError(l.LoadStream(strings.NewReader(`{"a": 1}`))) {
+		a.Equal(map[string]interface{}{"a": 1.0}, l.Map)
+	}
+	var sizeErr *SizeLimitError
+	if a.True(errors.As(l.LoadStream(strings.NewReader(`{"a": 10}`)), &sizeErr)) {
+		a.EqualValues(8, sizeErr.MaxBytes)
+	}
+	l.MaxBytes = 0
+	a.NoError(l.LoadStream(strings.NewReader(`{"a": 10}`)))
+
+	// MaxBytes counts the decompressed bytes
+	content := `{"a": "` + strings.Repeat("x", 1000) + `"}`
+	l.MaxBytes = 100
+	a.True(len(gzipped(content)) < 100)
+	a.True(errors.As(l.LoadStream(bytes.NewReader(gzipped(content))), &sizeErr))
+	l.MaxBytes = int64(len(content))
+	a.NoError(l.LoadStream(bytes.NewReader(gzipped(content))))
+}
+
+func TestDecoderMaxDepth(t *testing.T) {
+	a := assert.New(t)
+	var depthErr *DepthLimitError
+	d := &JSONDecoder{MaxDepth: 2}
+	_, err := d.Decode([]byte(`{"a": [1, 2], "b": {"c": 1}}`))
+	a.NoError(err)
+	_, err = d.Decode([]byte(`{"a": [1, {"b": 1}]}`))
+	if a.True(errors.As(err, &depthErr)) {
+		a.Equal(2, depthErr.MaxDepth)
+	}
+	_, err = d.Decode([]byte(`[[[1]]]`))
+	a.True(errors.As(err, &depthErr))
+	// the limit applies before decoding, even if the content is invalid
+	_, err = d.Decode([]byte(`[[[` + strings.Repeat("x", 10)))
+	a.True(errors.As(err, &depthErr))
+	_, err = (&JSONDecoder{}).Decode([]byte(`[[[1]]]`))
+	a.NoError(err)
+}
+
+func TestAutoDecoderOptions(t *testing.T) {
+	a := assert.New(t)
+	d := &AutoDecoder{JSON: &JSONDecoder{RejectDuplicateKeys: true}, YAML: &YAMLDecoder{StrictKeys: true}}
+	var dupErr *DuplicateKeyError
+	_, err := d.Decode([]byte(`{"a": 1, "a": 2}`))
+	a.True(errors.As(err, &dupErr))
+	var collisionErr *KeyCollisionError
+	_, err = d.Decode([]byte("true: 1\n\"true\": 2\n"))
+	a.True(errors.As(err, &collisionErr))
+	out, err := (&AutoDecoder{}).Decode([]byte("a:\n  b: 1\n"))
+	if a.NoError(err) {
+		a.Equal(map[string]interface{}{"a": map[string]interface{}{"b": 1}}, out)
+	}
+	l := &Loader{Decoder: d}
+	a.True(errors.As(l.LoadString(`{"a": 1, "a": 2}`), &dupErr))
+}
+
+// linesDecoder decodes lines of "key=value" for testing the registry
+
+type linesDecoder struct {
+	Separator string
+}
+
+func (d *linesDecoder) Decode(content []byte) (interface{}, error) {
+	out := make(map[string]interface{})
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		parts := strings.SplitN(line, d.Separator, 2)
+		if len(parts) != 2 {
+			return nil, errors.New("invalid line " + line)
+		}
+		out[parts[0]] = parts[1]
+	}
+	return out, nil
+}
+
+func TestLoadBytesWithType(t *testing.T) {
+	a := assert.New(t)
+	l := &Loader{}
+	for contentType, content := range map[string]string{
+		"application/json":            `{"a": 1}`,
+		"application/vnd.api+json":    `{"a": 1}`,
+		"application/x-yaml":          "a: 1",
+		"text/yaml; charset=utf-8":    "a: 1",
+		"application/vnd.custom+yaml": "a: 1",
+		"text/plain":                  "a: 1",
+		"application/octet-stream":    `{"a": 1}`,
+	} {
+		if a.NoError(l.LoadBytesWithType([]byte(content), contentType), contentType) {
+			a.EqualValues(1, l.Map["a"], contentType)
+		}
+	}
+	// the format is known, regardless of the content
+	a.Error(l.LoadBytesWithType([]byte("a: 1"), "application/json"))
+	a.Error(l.LoadBytesWithType([]byte(""), "invalid type"))
+
+	// the options of Decoder apply to the format
+	var dupErr *DuplicateKeyError
+	l.Decoder = &JSONDecoder{RejectDuplicateKeys: true}
+	a.True(errors.As(l.LoadBytesWithType([]byte(`{"a": 1, "a": 2}`), "application/json"), &dupErr))
+	l.Decoder = &AutoDecoder{JSON: &JSONDecoder{RejectDuplicateKeys: true}}
+	a.True(errors.As(l.LoadBytesWithType([]byte(`{"a": 1, "a": 2}`), "application/problem+json"), &dupErr))
+
+	// other formats are decoded by the registered decoders
+	RegisterDecoder("toml", &linesDecoder{Separator: " = "})
+	defer RegisterDecoder("toml", nil)
+	l = &Loader{}
+	if a.NoError(l.LoadBytesWithType([]byte("a = 1"), "application/toml")) {
+		a.Equal(map[string]interface{}{"a": "1"}, l.Map)
+	}
+}
+
+func TestUseDecoder(t *testing.T) {
+	a := assert.New(t)
+	l := &Loader{}
+	var unknownErr *UnknownDecoderError
+	if a.True(errors.As(l.UseDecoder("lines"), &unknownErr)) {
+		a.Equal("lines", unknownErr.Name)
+	}
+	a.Nil(l.Decoder)
+
+	registered := &linesDecoder{Separator: "="}
+	RegisterDecoder("lines", registered)
+	if a.NoError(l.UseDecoder("lines")) && a.NoError(l.LoadString("a=1\nb=2")) {
+		a.Equal(map[string]interface{}{"a": "1", "b": "2"}, l.Map)
+	}
+	// the options are changed for the Loader only
+	l.Decoder.(*linesDecoder).Separator = ":"
+	a.Equal("=", registered.Separator)
+	other := &Loader{}
+	if a.NoError(other.UseDecoder("lines")) {
+		a.Equal("=", other.Decoder.(*linesDecoder).Separator)
+	}
+
+	RegisterDecoder("lines", nil)
+	a.True(errors.As(other.UseDecoder("lines"), &unknownErr))
+
+	if a.NoError(l.UseDecoder("json")) {
+		l.Decoder.(*JSONDecoder).RejectDuplicateKeys = true
+		var dupErr *DuplicateKeyError
+		a.True(errors.As(l.LoadString(`{"a": 1, "a": 2}`), &dupErr))
+	}
+	if a.NoError(other.UseDecoder("json")) {
+		a.NoError(other.LoadString(`{"a": 1, "a": 2}`))
+	}
+	for _, name := range []string{"yaml", "auto"} {
+		a.NoError(l.UseDecoder(name))
+	}
+}
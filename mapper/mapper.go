@@ -1,10 +1,20 @@
 package mapper
 
 import (
+	"encoding"
 	"fmt"
+	"math"
+	"path"
 	"reflect"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codingbrain/mapper.go/errors"
 )
 
 // Compatible type classes
@@ -41,19 +51,35 @@ var (
 )
 
 func errNotStruct(loc string) error {
-	return fmt.Errorf("not a struct [%s]", loc)
+	return &NotStructError{Loc: loc}
 }
 
 func errNoSetValue(loc string) error {
-	return fmt.Errorf("not allowed to set value [%s]", loc)
+	return &NotSettableError{Loc: loc}
 }
 
 func errInvalidValue(loc string) error {
-	return fmt.Errorf("invalid value [%s]", loc)
+	return &InvalidValueError{Loc: loc}
+}
+
+func errKeyTypeMismatch(from, to reflect.Type, loc string) error {
+	return &KeyTypeError{Loc: loc, From: from, To: to}
+}
+
+func errElemTypeMismatch(from, to reflect.Type, loc string) error {
+	return &ElemTypeError{Loc: loc, From: from, To: to}
+}
+
+func errMismatch(from, to reflect.Type, loc string) error {
+	return &MismatchError{Loc: loc, From: from, To: to}
+}
+
+func errMarshal(err error, loc string) error {
+	return &MarshalError{Loc: loc, Err: err}
 }
 
-func errKeyTypeMismatch(loc string) error {
-	return fmt.Errorf("map key type mismatch [%s]", loc)
+func errAsType(asType string, loc string) error {
+	return &AsTypeError{Loc: loc, AsType: asType}
 }
 
 // FieldInfo contains parsed information from struct field
@@ -64,6 +90,26 @@ type FieldInfo struct {
 	Wildcard  bool
 	Ignore    bool
 	MapName   string
+	// Pattern is the glob pattern of a wildcard map field, which only
+	// accepts the leftover keys matching the pattern
+	Pattern string
+	// AsType forces the type of the value on map output,
+	// one of float64, int64, string, bool
+	AsType string
+	// Converter is the name of the FieldConverter registered in
+	// Mapper.FieldConverters, which converts the value of the field
+	Converter string
+	// CaseInsensitive matches the source key case-insensitively when
+	// there's no exact match, regardless of Mapper.CaseInsensitive
+	CaseInsensitive bool
+	// CaseSensitive only matches the exact source key, regardless of
+	// Mapper.CaseInsensitive
+	CaseSensitive bool
+	// KeyField receives the map key when the struct is mapped as
+	// an element of a map
+	KeyField bool
+	// KeepEmpty emits the field even if it's empty with Mapper.OmitEmptyAll
+	KeepEmpty bool
 }
 
 // TypeClass converts reflect.Kind to compatible class
@@ -103,8 +149,27 @@ func TypeClass(kind reflect.Kind) int {
 	panic("Unknown kind " + kind.String())
 }
 
+type typePair struct {
+	from, to reflect.Type
+}
+
+var (
+	compatibilityCache sync.Map
+	converterCache     sync.Map
+)
+
 // TypeCompatibility determines the assignment/conversion compatibility
 func TypeCompatibility(from, to reflect.Type) int {
+	key := typePair{from: from, to: to}
+	if c, ok := compatibilityCache.Load(key); ok {
+		return c.(int)
+	}
+	c := typeCompatibility(from, to)
+	compatibilityCache.Store(key, c)
+	return c
+}
+
+func typeCompatibility(from, to reflect.Type) int {
 	if from.AssignableTo(to) {
 		return Assignable
 	} else if from.ConvertibleTo(to) {
@@ -126,7 +191,18 @@ func TypeCompatibility(from, to reflect.Type) int {
 type TypeConverter func(reflect.Value) reflect.Value
 
 // TypeConverterFactory creates the converter by types
+// The converters are cached per pair of types
 func TypeConverterFactory(from, to reflect.Type) TypeConverter {
+	key := typePair{from: from, to: to}
+	if c, ok := converterCache.Load(key); ok {
+		return c.(TypeConverter)
+	}
+	c := typeConverterFactory(from, to)
+	converterCache.Store(key, c)
+	return c
+}
+
+func typeConverterFactory(from, to reflect.Type) TypeConverter {
 	switch TypeCompatibility(from, to) {
 	case Assignable:
 		return func(v reflect.Value) reflect.Value { return v }
@@ -151,6 +227,36 @@ func TypeConverterFactory(from, to reflect.Type) TypeConverter {
 	return nil
 }
 
+var (
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	stringerType        = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	timeType            = reflect.TypeOf(time.Time{})
+)
+
+// keyConverterFactory extends TypeConverterFactory for map keys, and
+// renders keys implementing encoding.TextMarshaler or fmt.Stringer into
+// string keys. Without either, the keys are not convertible to strings
+func keyConverterFactory(from, to reflect.Type) TypeConverter {
+	if convFn := TypeConverterFactory(from, to); convFn != nil || to.Kind() != reflect.String {
+		return convFn
+	}
+	if from.Implements(textMarshalerType) {
+		return func(v reflect.Value) (r reflect.Value) {
+			if text, err := v.Interface().(encoding.TextMarshaler).MarshalText(); err == nil {
+				r = reflect.ValueOf(string(text)).Convert(to)
+			}
+			return
+		}
+	}
+	if from.Implements(stringerType) {
+		return func(v reflect.Value) reflect.Value {
+			return reflect.ValueOf(v.Interface().(fmt.Stringer).String()).Convert(to)
+		}
+	}
+	return nil
+}
+
 // UnwrapInterface returns the actual value of the interface
 func UnwrapInterface(v reflect.Value) reflect.Value {
 	for v.Kind() == reflect.Interface {
@@ -199,7 +305,9 @@ func IsEmpty(v reflect.Value) bool {
 		case InterfaceClass:
 			v = UnwrapInterface(v)
 		case PtrClass:
-			v = UnwrapPtr(v)
+			// like encoding/json, only a nil pointer is empty,
+			// a pointer to a zero value is not
+			return v.IsNil()
 		case UnsafePointerClass:
 			return v.Pointer() == 0
 		default:
@@ -208,6 +316,15 @@ func IsEmpty(v reflect.Value) bool {
 	}
 }
 
+// IsScalarType determines if the type is a bool, number or string
+func IsScalarType(t reflect.Type) bool {
+	switch TypeClass(t.Kind()) {
+	case BoolClass, IntClass, UintClass, FloatClass, ComplexClass, StringClass:
+		return true
+	}
+	return false
+}
+
 // IsContainer determine if the value is map or struct
 func IsContainer(v reflect.Value) bool {
 	switch TypeClass(v.Kind()) {
@@ -217,39 +334,493 @@ func IsContainer(v reflect.Value) bool {
 	return false
 }
 
+// PreMapper is implemented by types which need preparation before
+// being mapped from a map
+type PreMapper interface {
+	BeforeMap() error
+}
+
+// PostMapper is implemented by types which need validation or
+// normalization after all fields are mapped from a map
+type PostMapper interface {
+	AfterMap() error
+}
+
+// MapDataer is implemented by types which represent themselves as a map,
+// instead of mapping the fields
+type MapDataer interface {
+	ToMapData() map[string]interface{}
+}
+
+// MapDataLoader is implemented by types which populate themselves from
+// a map, instead of mapping the fields
+type MapDataLoader interface {
+	FromMapData(map[string]interface{}) error
+}
+
+// ValueReceiver is implemented by wrapper types, e.g. a generic
+// Optional[T], which receive the source value into the wrapped value,
+// instead of mapping the fields
+type ValueReceiver interface {
+	// ValuePtr returns the pointer to the wrapped value to map into
+	ValuePtr() interface{}
+	// ValueReceived is called after the source is mapped into the value
+	ValueReceived()
+}
+
+func errLifecycle(err error, loc string) error {
+	return &HookError{Loc: loc, Err: err}
+}
+
+func callBeforeMap(d reflect.Value, loc string) error {
+	if d.CanAddr() {
+		if pm, ok := d.Addr().Interface().(PreMapper); ok {
+			if err := pm.BeforeMap(); err != nil {
+				return errLifecycle(err, loc)
+			}
+		}
+	}
+	return nil
+}
+
+func callAfterMap(d reflect.Value, loc string) error {
+	if d.CanAddr() {
+		if pm, ok := d.Addr().Interface().(PostMapper); ok {
+			if err := pm.AfterMap(); err != nil {
+				return errLifecycle(err, loc)
+			}
+		}
+	}
+	return nil
+}
+
+// toMapData returns the map from MapDataer implemented by the value
+// or the pointer to the value
+func toMapData(v reflect.Value) (map[string]interface{}, bool) {
+	if v.CanAddr() {
+		v = v.Addr()
+	}
+	if v.CanInterface() {
+		if md, ok := v.Interface().(MapDataer); ok {
+			return md.ToMapData(), true
+		}
+	}
+	return nil, false
+}
+
 // MapTracer receives the traversal in mapping
 type MapTracer func(d, s reflect.Value, loc string)
 
-// Mapper assign dynamic values
+// MapGuard checks each step in mapping, and aborts it with an error
+type MapGuard func(d, s reflect.Value, loc string) error
+
+// FieldConverter converts the value of a field referencing it by the
+// conv tag option, and the result is assigned to the field when mapping
+// into a struct, or set into the map when mapping a struct to a map.
+// The loc of the field is composed in LocFormat, e.g. "*.Items.0.Amount"
+// when mapping into a pointer to a struct, where "*" is a dereference,
+// "@" is the value held by an interface, and the other components are
+// field names, slice indices or map keys, or ".Amount" when mapping
+// a struct to a map. With JSONPointer, map names are used for fields,
+// e.g. "/items/0/amount"
+type FieldConverter func(v reflect.Value, loc string) (reflect.Value, error)
+
+// ResultTracer receives the result of the assignment to a scalar or
+// interface destination
+type ResultTracer func(loc string, assigned bool, err error)
+
+// ConvertTracer receives the conversions between non-assignable types
+type ConvertTracer func(from, to reflect.Type, loc string)
+
+// Mapper assign dynamic values.
+// A Mapper can be shared by concurrent mappings once configured, except
+// with TrackPresence, SharePointers, NeverAlias, MapReport and MapTimeout,
+// which keep the state of a mapping in the Mapper, so each concurrent
+// mapping needs its own Mapper
 type Mapper struct {
 	FieldTags []string
 	Tracer    MapTracer
+	// OptionsTag is the tag of the mapping options separated by commas,
+	// like squash in `json:"name" mapper:"squash"`, where wildcard and
+	// ignore (or "-") are also accepted. The options are combined with
+	// those after the map name, and astype and conv override them
+	OptionsTag string
+	// ResultTracer is called when an assignment to a scalar or interface
+	// destination returns, unlike Tracer which is called on entry
+	ResultTracer ResultTracer
+	// Guard is called at each step like Tracer, and a non-nil error
+	// aborts the mapping with the error, e.g. to forbid certain paths
+	Guard MapGuard
+	// OnConvert is invoked when a value is converted to a different type
+	OnConvert ConvertTracer
+	// EmptyFunc overrides IsEmpty for specific types when omitempty is
+	// evaluated. It only affects struct to map emission, not assignment
+	EmptyFunc map[reflect.Type]func(reflect.Value) bool
+	// IsEmptyFunc overrides IsEmpty, and IsContainerFunc overrides
+	// IsContainer, e.g. to let custom container types be merged
+	IsEmptyFunc     func(reflect.Value) bool
+	IsContainerFunc func(reflect.Value) bool
+	// NoInterfaceMerge stores the source as-is into a settable interface
+	// destination, instead of merging into the container it holds
+	NoInterfaceMerge bool
+	// KeyValidators validates the keys of the type when mapping into
+	// a map, e.g. to accept only known values of an enum type
+	KeyValidators map[reflect.Type]func(reflect.Value) bool
+	// InterfaceResolvers picks the concrete type to allocate for a nil
+	// destination of the interface type from the source value, e.g. by
+	// a discriminator key, and nil keeps the source value as-is
+	InterfaceResolvers map[reflect.Type]func(reflect.Value) reflect.Type
+	// AnnotateTypes wraps the values of non-nil interface fields when
+	// mapping a struct to a map, e.g. {"@type": "pkg.Type", "value": ...},
+	// so the concrete type is recoverable
+	AnnotateTypes bool
+	// TypeKey and TypeValueKey are the keys of the wrapper by AnnotateTypes,
+	// and "@type" and "value" are used if empty
+	TypeKey      string
+	TypeValueKey string
+	// RecoverPanics converts panics during mapping, e.g. from reflect,
+	// into PanicError, instead of crashing the caller
+	RecoverPanics bool
+	// CaseInsensitive matches source keys to all fields case-insensitively
+	// when there's no exact match, and the ci and cs tag options enable
+	// and disable it for a single field
+	CaseInsensitive bool
+	// UseSetters enables calling setter methods on the struct for the
+	// keys without a matching field, e.g. SetName(v) for key name. A setter
+	// takes one argument, and optionally returns an error
+	UseSetters bool
+	// PairKeyField and PairValueField are the map names of the fields of
+	// a struct, which receive the key and the value of each map entry when
+	// mapping a map into a slice of the struct, e.g. Key and Value for
+	// []struct{ Key string; Value int }. Both must be set to enable it
+	PairKeyField   string
+	PairValueField string
+	// SortPairs sorts the map entries by keys when mapping a map into
+	// a slice of pairs, otherwise the order is undefined
+	SortPairs bool
+	// NumericMapToSlice enables mapping a map with numeric keys into
+	// a slice, e.g. {"0": "a", "2": "c"}, where the keys are indices
+	// and gaps are zero values
+	NumericMapToSlice bool
+	// NumericIndexLimit bounds the indices accepted by NumericMapToSlice,
+	// and a larger index is an InvalidKeyError instead of allocating a huge
+	// slice. Zero means DefaultNumericIndexLimit
+	NumericIndexLimit int
+	// ParallelSliceThreshold enables concurrent mapping of slice elements
+	// when the slice is longer than the threshold. Zero disables it.
+	// When enabled, Tracer, EmptyFunc and any other registered functions
+	// may be invoked from multiple goroutines and must be safe for
+	// concurrent use
+	ParallelSliceThreshold int
+	// NeverAlias forces field by field copying of assignable structs
+	// and allocation of new pointers, so the destination doesn't share
+	// pointers with the source. The pointers, maps and slices held in
+	// interfaces are copied too, and a pointer seen again in the same
+	// mapping is mapped to the same copy, so cycles are copied as cycles.
+	// Unexported fields can't be set, and are still shared
+	NeverAlias bool
+	// TrackPresence enables recording of struct fields whose keys are
+	// present in the source map, even if the value is null
+	TrackPresence bool
+	// Presence is reset by Map and MapValue when TrackPresence is set, and
+	// contains the locations (e.g. "*.Timeout") of present fields
+	Presence map[string]bool
+	// EmptyMapResetsStruct zeroes the destination struct when the source
+	// is an empty map. Otherwise, like an absent key or a null value,
+	// an empty map leaves the existing fields of the struct untouched
+	EmptyMapResetsStruct bool
+	// WildcardAll assigns a non-map source to all the wildcard fields it
+	// converts to, instead of only the first one in declaration order
+	WildcardAll bool
+	// SharePointers maps the same source map into the same pointer when
+	// allocating nil pointer destinations of the same type, e.g. for the
+	// anchored mappings and aliases decoded by yamlv3.Decoder
+	SharePointers bool
+
+	// MergeStrategy determines how conflicting values are merged
+	MergeStrategy MergeStrategy
+	// FormDecode enables mapping from form values like url.Values:
+	// a []string is mapped to a scalar by its first element, and an empty
+	// []string is treated as absent
+	FormDecode bool
+	// FormKeyFormat determines how the keys of nested values are composed
+	// by ToValues
+	FormKeyFormat FormKeyFormat
+	// RejectNonFinite fails the mapping when Inf or NaN is assigned to
+	// a float destination
+	RejectNonFinite bool
+	// ComplexFormat determines how complex numbers are represented in maps
+	ComplexFormat ComplexFormat
+	// ByteSliceFormat determines how byte slices are represented in maps
+	ByteSliceFormat ByteSliceFormat
+	// ChannelIO sends the elements of a slice into a channel destination,
+	// and receives the elements of a channel into a slice destination,
+	// instead of assigning the channel itself
+	ChannelIO bool
+	// ChannelBound limits the number of elements received from a channel
+	// with ChannelIO, and 0 receives until the channel is empty
+	ChannelBound int
+	// PreserveTypes lists the types of struct fields which are left
+	// untouched when mapping into a struct, e.g. an injected io.Writer
+	PreserveTypes []reflect.Type
+	// ValueTransform is invoked before a value is assigned to a scalar or
+	// interface destination of type dst, and returns the value to assign
+	// instead, or false to leave it as-is. Returning an invalid value
+	// leaves the destination unchanged
+	ValueTransform func(dst reflect.Type, v reflect.Value, loc string) (reflect.Value, bool)
+	// FloatToInt determines how floats are mapped to integer destinations,
+	// which are rejected by default
+	FloatToInt FloatToIntMode
+	// ParseBoolStrings enables mapping strings to bool destinations
+	// by looking up BoolStrings
+	ParseBoolStrings bool
+	// BoolStrings maps lower case strings to bool values, and
+	// DefaultBoolStrings is used if nil
+	BoolStrings map[string]bool
+	// FuncAdapter creates the converter adapting a function to a function
+	// destination of a different signature, or returns nil if not supported
+	FuncAdapter func(dst, src reflect.Type) TypeConverter
+	// FieldConverters are the converters referenced by name from the
+	// conv tag option, e.g. `map:"amount,conv=cents"`
+	FieldConverters map[string]FieldConverter
+	// TimeLayout is the layout to format time.Time fields when mapping
+	// a struct to a map, and to parse strings into time.Time fields,
+	// and time.RFC3339 is used if empty
+	TimeLayout string
+	// EmitNulls emits nil pointer, interface, slice and map fields as
+	// untyped nil values when mapping a struct to a map, which are
+	// otherwise emitted as typed nil values
+	EmitNulls bool
+	// OmitEmptyAll leaves out empty fields and fields of zero values when
+	// mapping a struct to a map, like a global omitempty, except the fields
+	// with the keepempty option, e.g. `map:"count,keepempty"`
+	OmitEmptyAll bool
+	// OmitEmptyTypes leaves out the empty fields and the fields of zero
+	// values of the types when true, or keeps them when false, overriding
+	// OmitEmptyAll. The omitempty and keepempty options take precedence
+	OmitEmptyTypes map[reflect.Type]bool
+	// OnlyFields lists the map names of the fields emitted when mapping
+	// a struct of the type to a map, and other fields are left out.
+	// The list also applies to the fields promoted from anonymous and
+	// squashed structs, unless only the embedded type is listed
+	OnlyFields map[reflect.Type][]string
+	// LocFormat determines the format of locations in errors and tracers
+	LocFormat LocFormat
+
+	presenceLock sync.Mutex
+	// shared keeps the pointers allocated for source maps with SharePointers
+	shared map[sharedPtr]reflect.Value
+	// copies keeps the pointers allocated for source pointers with
+	// NeverAlias, so cycles are copied as cycles
+	copies     map[sharedPtr]reflect.Value
+	sharedLock sync.Mutex
+	// report is collected by MapReport
+	report     *Report
+	reportLock sync.Mutex
+	// timeout is set by MapTimeout to abort the mapping
+	timeout int64
+	// depthsCache and planCache keep the promoted depths of struct types
+	// and the plans of struct to struct mapping, keyed with the tags the
+	// field infos depend on
+	depthsCache sync.Map
+	planCache   sync.Map
+}
+
+// DefaultNumericIndexLimit is the largest index accepted by
+// NumericMapToSlice unless Mapper.NumericIndexLimit is set
+const DefaultNumericIndexLimit = 65535
+
+// LocFormat defines the format of locations
+type LocFormat int
+
+// Location formats
+const (
+	// Dotted composes locations like "*.Items.0.Name"
+	Dotted LocFormat = iota
+	// JSONPointer composes locations as RFC 6901 JSON Pointers like
+	// "/items/0/name", using map names for struct fields
+	JSONPointer
+)
+
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// PathEscape is the escape character in dotted paths, e.g. a\.b is
+// the key "a.b", and a\\b is the key "a\b"
+const PathEscape = '\\'
+
+var dottedEscaper = strings.NewReplacer(`\`, `\\`, ".", `\.`)
+
+// EscapePathKey escapes the dots and escape characters in the key for
+// dotted paths
+func EscapePathKey(key string) string {
+	if strings.ContainsAny(key, `.\`) {
+		return dottedEscaper.Replace(key)
+	}
+	return key
+}
+
+// SplitPath splits the dotted path into keys, and unescapes the keys
+// escaped by EscapePathKey
+func SplitPath(path string) []string {
+	if !strings.ContainsRune(path, PathEscape) {
+		return strings.Split(path, ".")
+	}
+	var keys []string
+	var key strings.Builder
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; {
+		case c == PathEscape && i+1 < len(path):
+			i++
+			key.WriteByte(path[i])
+		case c == '.':
+			keys = append(keys, key.String())
+			key.Reset()
+		default:
+			key.WriteByte(c)
+		}
+	}
+	return append(keys, key.String())
+}
+
+func (m *Mapper) locExp(loc, comp string) string {
+	if m.LocFormat == JSONPointer {
+		return loc + "/" + jsonPointerEscaper.Replace(comp)
+	}
+	return loc + "." + EscapePathKey(comp)
 }
 
-func locExp(loc, comp string) string {
-	return loc + "." + comp
+func (m *Mapper) locField(loc string, field reflect.StructField, info *FieldInfo) string {
+	if m.LocFormat == JSONPointer {
+		if field.Anonymous || info.Squash || info.Wildcard {
+			return loc
+		}
+		return m.locExp(loc, info.MapName)
+	}
+	return m.locExp(loc, field.Name)
 }
 
-func locPtr(loc string) string {
+func (m *Mapper) locPtr(loc string) string {
+	if m.LocFormat == JSONPointer {
+		return loc
+	}
 	return loc + "*"
 }
 
-func locInterface(loc string) string {
+func (m *Mapper) locInterface(loc string) string {
+	if m.LocFormat == JSONPointer {
+		return loc
+	}
 	return loc + "@"
 }
 
+func (m *Mapper) locMerge(loc string) string {
+	if m.LocFormat == JSONPointer {
+		return loc
+	}
+	return m.locExp(loc, "+")
+}
+
 func (m *Mapper) traceMap(d, s reflect.Value, loc string) {
 	if m.Tracer != nil {
 		m.Tracer(d, s, loc)
 	}
 }
 
+func (m *Mapper) traceConvert(from, to reflect.Type, loc string) {
+	if m.OnConvert != nil {
+		m.OnConvert(from, to, loc)
+	}
+}
+
+func (m *Mapper) markPresent(loc string) {
+	if m.TrackPresence {
+		m.presenceLock.Lock()
+		if m.Presence == nil {
+			m.Presence = make(map[string]bool)
+		}
+		m.Presence[loc] = true
+		m.presenceLock.Unlock()
+	}
+}
+
+func (m *Mapper) isPreserved(t reflect.Type) bool {
+	for _, preserved := range m.PreserveTypes {
+		if t == preserved {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Mapper) isEmpty(v reflect.Value) bool {
+	if m.IsEmptyFunc != nil {
+		return m.IsEmptyFunc(v)
+	}
+	return IsEmpty(v)
+}
+
+func (m *Mapper) isContainer(v reflect.Value) bool {
+	if m.IsContainerFunc != nil {
+		return m.IsContainerFunc(v)
+	}
+	return IsContainer(v)
+}
+
+func (m *Mapper) omitEmpty(v reflect.Value) bool {
+	if v.IsValid() {
+		if fn, ok := m.EmptyFunc[v.Type()]; ok {
+			return fn(v)
+		}
+	}
+	return m.isEmpty(v)
+}
+
+// omitField determines if the field is left out of the map output.
+// The field options are evaluated first: an empty field is omitted with
+// omitempty, and kept with keepempty. Then OmitEmptyTypes decides by the
+// field type, and finally OmitEmptyAll. Without omitempty, a field is
+// omitted if it's empty or the zero value
+func (m *Mapper) omitField(info *FieldInfo, v reflect.Value) bool {
+	switch {
+	case info.OmitEmpty:
+		return m.omitEmpty(v)
+	case info.KeepEmpty || !v.IsValid():
+		return false
+	}
+	omit := m.OmitEmptyAll
+	if byType, ok := m.OmitEmptyTypes[v.Type()]; ok {
+		omit = byType
+	}
+	return omit && (m.omitEmpty(v) || v.IsZero())
+}
+
 func (m *Mapper) assignValue(d, s reflect.Value, loc string) (assigned bool, err error) {
 	m.traceMap(d, s, loc)
+	if m.Guard != nil {
+		if err = m.Guard(d, s, loc); err != nil {
+			return false, err
+		}
+	}
 
+	if timeout := atomic.LoadInt64(&m.timeout); timeout != 0 {
+		return false, &TimeoutError{Loc: loc, Timeout: time.Duration(timeout)}
+	}
 	if !d.IsValid() {
 		return false, errInvalidValue(loc)
 	}
+	if m.ResultTracer != nil && (IsScalarType(d.Type()) || d.Kind() == reflect.Interface) {
+		defer func() {
+			m.ResultTracer(loc, assigned, err)
+		}()
+	}
+	if m.RecoverPanics {
+		// the deepest assignValue recovers the panic with its loc
+		defer func() {
+			if r := recover(); r != nil {
+				assigned, err = false, &PanicError{Loc: loc, Value: r}
+			}
+		}()
+	}
 	if !s.IsValid() {
 		return
 	}
@@ -267,14 +838,41 @@ func (m *Mapper) assignValue(d, s reflect.Value, loc string) (assigned bool, err
 			return
 		}
 	}
+	if s.Kind() == reflect.Ptr && s.IsNil() {
+		// like a nil value, a nil pointer leaves the destination untouched
+		return
+	}
+
+	if m.FormDecode && s.Kind() == reflect.Slice && s.Type().Elem().Kind() == reflect.String {
+		if s.Len() == 0 {
+			return
+		}
+		if TypeClass(d.Kind()) != SliceClass {
+			s = s.Index(0)
+		}
+	}
 
 	switch TypeClass(d.Kind()) {
 	case SliceClass:
-		assigned, err = m.assignToSlice(d, s, loc)
+		if assigned, err = m.assignBytes(d, s, loc); !assigned && err == nil {
+			assigned, err = m.assignToSlice(d, s, loc)
+		}
 	case MapClass:
 		assigned, err = m.assignToMap(d, s, loc)
 	case StructClass:
-		assigned, err = m.assignToStruct(d, s, loc)
+		if isBigType(d.Type()) {
+			assigned, err = m.assignToBig(d, s, loc)
+		} else {
+			assigned, err = m.assignToStruct(d, s, loc)
+		}
+	case ComplexClass:
+		assigned, err = m.assignToComplex(d, s, loc)
+	case FuncClass:
+		assigned, err = m.assignToFunc(d, s, loc)
+	case ChanClass:
+		if assigned, err = m.assignToChan(d, s, loc); !assigned && err == nil {
+			assigned, err = m.assignToOther(d, s, loc)
+		}
 	default:
 		assigned, err = m.assignToOther(d, s, loc)
 	}
@@ -285,60 +883,217 @@ func (m *Mapper) assignValue(d, s reflect.Value, loc string) (assigned bool, err
 		return m.assignValue(d, s.Elem(), loc)
 	}
 
-	return false, fmt.Errorf("unable to assign from type %s to %s [%s]",
-		s.Kind().String(), d.Kind().String(), loc)
+	return false, errMismatch(s.Type(), d.Type(), loc)
 }
 
 func (m *Mapper) assignToPtr(d, s reflect.Value, loc string) (bool, error) {
-	if d.CanSet() && s.Type().ConvertibleTo(d.Type()) {
+	if d.CanSet() && (!m.NeverAlias || s.Kind() != reflect.Ptr) && s.Type().ConvertibleTo(d.Type()) {
 		d.Set(s.Convert(d.Type()))
 		return true, nil
 	}
+	copyKey, copying := m.copiedPtrKey(d, s)
 	if !d.IsNil() {
-		return m.assignValue(d.Elem(), s, locPtr(loc))
+		if copying {
+			// a cycle back to s ends at d
+			m.storeCopy(copyKey, d)
+		}
+		return m.assignValue(d.Elem(), s, m.locPtr(loc))
+	}
+	if copying {
+		if p, ok := m.loadCopy(copyKey); ok {
+			d.Set(p)
+			return true, nil
+		}
+	}
+	key, shareable := m.sharedPtrKey(d, s)
+	if shareable {
+		if p, ok := m.loadShared(key); ok {
+			d.Set(p)
+			return true, nil
+		}
 	}
 	v := reflect.New(d.Type().Elem())
-	assigned, err := m.assignValue(v.Elem(), s, locPtr(loc))
+	if copying {
+		// stored before mapping, so a cycle back to s ends at the copy
+		m.storeCopy(copyKey, v)
+	}
+	assigned, err := m.assignValue(v.Elem(), s, m.locPtr(loc))
 	if err == nil && assigned {
 		d.Set(v)
+		if shareable {
+			m.storeShared(key, v)
+		}
 	}
 	return assigned, err
 }
 
+// sharedPtr identifies a source map and the pointer type it's mapped to
+type sharedPtr struct {
+	src uintptr
+	typ reflect.Type
+}
+
+func (m *Mapper) sharedPtrKey(d, s reflect.Value) (sharedPtr, bool) {
+	if !m.SharePointers {
+		return sharedPtr{}, false
+	}
+	s = UnwrapInterface(s)
+	if s.Kind() != reflect.Map || s.IsNil() {
+		return sharedPtr{}, false
+	}
+	return sharedPtr{src: s.Pointer(), typ: d.Type()}, true
+}
+
+func (m *Mapper) loadShared(key sharedPtr) (reflect.Value, bool) {
+	m.sharedLock.Lock()
+	defer m.sharedLock.Unlock()
+	p, ok := m.shared[key]
+	return p, ok
+}
+
+func (m *Mapper) storeShared(key sharedPtr, p reflect.Value) {
+	m.sharedLock.Lock()
+	defer m.sharedLock.Unlock()
+	if m.shared == nil {
+		m.shared = make(map[sharedPtr]reflect.Value)
+	}
+	m.shared[key] = p
+}
+
+// copiedPtrKey identifies a source pointer copied with NeverAlias
+func (m *Mapper) copiedPtrKey(d, s reflect.Value) (sharedPtr, bool) {
+	if !m.NeverAlias {
+		return sharedPtr{}, false
+	}
+	s = UnwrapInterface(s)
+	if s.Kind() != reflect.Ptr || s.IsNil() {
+		return sharedPtr{}, false
+	}
+	return sharedPtr{src: s.Pointer(), typ: d.Type()}, true
+}
+
+func (m *Mapper) loadCopy(key sharedPtr) (reflect.Value, bool) {
+	m.sharedLock.Lock()
+	defer m.sharedLock.Unlock()
+	p, ok := m.copies[key]
+	return p, ok
+}
+
+func (m *Mapper) storeCopy(key sharedPtr, p reflect.Value) {
+	m.sharedLock.Lock()
+	defer m.sharedLock.Unlock()
+	if m.copies == nil {
+		m.copies = make(map[sharedPtr]reflect.Value)
+	}
+	m.copies[key] = p
+}
+
+// tryMergeContainers merges the source into the existing container,
+// including a struct or map held by interface or pointer, e.g. a non-nil
+// *struct element of a map is updated in place instead of replaced
 func (m *Mapper) tryMergeContainers(d, s reflect.Value, loc string) (assigned bool, err error) {
 	unwD := UnwrapAny(d)
 	unwS := UnwrapAny(s)
-	if IsContainer(unwD) && IsContainer(unwS) {
-		return m.assignValue(unwD, unwS, locExp(loc, "+"))
+	if m.isContainer(unwD) && m.isContainer(unwS) {
+		return m.assignValue(unwD, unwS, m.locMerge(loc))
 	}
 	return
 }
 
 func (m *Mapper) assignToInterface(d, s reflect.Value, loc string) (assigned bool, err error) {
-	if d.IsValid() {
+	if resolve, ok := m.InterfaceResolvers[d.Type()]; ok && d.CanSet() && d.IsNil() {
+		if t := resolve(s); t != nil {
+			return m.assignResolved(d, s, t, loc)
+		}
+	}
+	if m.NeverAlias && d.CanSet() && d.IsNil() {
+		if src := UnwrapInterface(s); isReference(src) && src.Type().AssignableTo(d.Type()) {
+			// the pointer, map or slice held by the interface is copied
+			v := reflect.New(src.Type()).Elem()
+			if assigned, err = m.assignValue(v, src, m.locInterface(loc)); err == nil && assigned {
+				d.Set(v)
+			}
+			return
+		}
+	}
+	if d.IsValid() && !(m.NoInterfaceMerge && d.CanSet()) {
+		if d.CanSet() && d.Elem().Kind() == reflect.Struct && UnwrapAny(s).Kind() == reflect.Map {
+			return m.assignToInterfaceStruct(d, UnwrapAny(s), loc)
+		}
 		assigned, err = m.tryMergeContainers(d, s, loc)
 		if err != nil || assigned {
 			return
 		}
 
 		if !d.CanSet() {
-			return m.assignValue(d.Elem(), s, locInterface(loc))
+			return m.assignValue(d.Elem(), s, m.locInterface(loc))
 		}
 	}
 	return m.assignToOther(d, s, loc)
 }
 
+// isReference checks whether v is a non-nil pointer, map or slice, which
+// would be shared by assigning v
+func isReference(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		return !v.IsNil()
+	}
+	return false
+}
+
+// assignResolved maps into a new value of the type resolved by
+// InterfaceResolvers, and assigns it to the interface
+func (m *Mapper) assignResolved(d, s reflect.Value, t reflect.Type, loc string) (assigned bool, err error) {
+	if !t.Implements(d.Type()) {
+		return false, errMismatch(t, d.Type(), loc)
+	}
+	v := reflect.New(t).Elem()
+	if assigned, err = m.assignValue(v, s, m.locInterface(loc)); err == nil && assigned {
+		d.Set(v)
+	}
+	return
+}
+
+// assignToInterfaceStruct maps into a settable copy of the struct value
+// held by the interface, as the held value itself is not addressable
+func (m *Mapper) assignToInterfaceStruct(d, s reflect.Value, loc string) (assigned bool, err error) {
+	v := reflect.New(d.Elem().Type()).Elem()
+	v.Set(d.Elem())
+	if assigned, err = m.assignValue(v, s, m.locInterface(loc)); err == nil && assigned {
+		d.Set(v)
+	}
+	return
+}
+
 func (m *Mapper) assignToSlice(d, s reflect.Value, loc string) (assigned bool, err error) {
+	if m.NumericMapToSlice && s.Kind() == reflect.Map {
+		return m.assignNumericMapToSlice(d, s, loc)
+	}
+	if s.Kind() == reflect.Map && m.PairKeyField != "" && m.PairValueField != "" {
+		if assigned, err = m.assignMapToPairs(d, s, loc); assigned || err != nil {
+			return
+		}
+	}
+	if m.ChannelIO && s.Kind() == reflect.Chan {
+		return m.assignFromChan(d, s, loc)
+	}
 	if TypeClass(s.Kind()) == SliceClass {
 		if !d.CanSet() {
 			return false, errNoSetValue(loc)
 		}
+		// nil source elements leave the elements of the new slice as
+		// zero values, e.g. nil pointers instead of pointers to zero values
 		v := reflect.MakeSlice(d.Type(), s.Len(), s.Len())
 		if s.Len() == 0 {
 			assigned = true
+		} else if m.ParallelSliceThreshold > 0 && s.Len() > m.ParallelSliceThreshold {
+			if assigned, err = m.assignSliceElemsParallel(v, s, loc); err != nil {
+				return false, err
+			}
 		} else {
 			for i := 0; i < s.Len(); i++ {
-				if a, err := m.assignValue(v.Index(i), s.Index(i), locExp(loc, strconv.Itoa(i))); err != nil {
+				if a, err := m.assignValue(v.Index(i), s.Index(i), m.locExp(loc, strconv.Itoa(i))); err != nil {
 					return false, err
 				} else if a {
 					assigned = true
@@ -352,6 +1107,173 @@ func (m *Mapper) assignToSlice(d, s reflect.Value, loc string) (assigned bool, e
 	return
 }
 
+// pairFields returns the indices of the key and value fields of the struct
+// type, or false if the type doesn't have both of them
+func (m *Mapper) pairFields(t reflect.Type) (keyIndex, valIndex int, ok bool) {
+	if t.Kind() != reflect.Struct {
+		return 0, 0, false
+	}
+	keyIndex, valIndex = -1, -1
+	for i := 0; i < t.NumField(); i++ {
+		info := m.ParseField(t.Field(i))
+		if !info.Exported || info.Ignore {
+			continue
+		}
+		switch info.MapName {
+		case m.PairKeyField:
+			keyIndex = i
+		case m.PairValueField:
+			valIndex = i
+		}
+	}
+	return keyIndex, valIndex, keyIndex >= 0 && valIndex >= 0
+}
+
+// assignMapToPairs maps each entry of the map into an element of a slice
+// of structs with the fields PairKeyField and PairValueField
+func (m *Mapper) assignMapToPairs(d, s reflect.Value, loc string) (assigned bool, err error) {
+	elemType := d.Type().Elem()
+	structType := elemType
+	if structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	keyIndex, valIndex, ok := m.pairFields(structType)
+	if d.Kind() != reflect.Slice || !ok {
+		return false, nil
+	}
+	if !d.CanSet() {
+		return false, errNoSetValue(loc)
+	}
+	keys := s.MapKeys()
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		names[i] = fmt.Sprintf("%v", UnwrapAny(key))
+	}
+	if m.SortPairs {
+		sort.Sort(&keysByName{keys: keys, names: names})
+	}
+	v := reflect.MakeSlice(d.Type(), len(keys), len(keys))
+	for i, key := range keys {
+		elem := v.Index(i)
+		if elemType.Kind() == reflect.Ptr {
+			elem.Set(reflect.New(structType))
+			elem = elem.Elem()
+		}
+		elemLoc := m.locExp(loc, names[i])
+		if _, err = m.assignValue(elem.Field(keyIndex), key, elemLoc); err != nil {
+			return false, err
+		}
+		if _, err = m.assignValue(elem.Field(valIndex), s.MapIndex(key), elemLoc); err != nil {
+			return false, err
+		}
+	}
+	d.Set(v)
+	return true, nil
+}
+
+// keysByName sorts map keys by the string representations
+type keysByName struct {
+	keys  []reflect.Value
+	names []string
+}
+
+func (k *keysByName) Len() int           { return len(k.keys) }
+func (k *keysByName) Less(i, j int) bool { return k.names[i] < k.names[j] }
+func (k *keysByName) Swap(i, j int) {
+	k.keys[i], k.keys[j] = k.keys[j], k.keys[i]
+	k.names[i], k.names[j] = k.names[j], k.names[i]
+}
+
+func (m *Mapper) assignNumericMapToSlice(d, s reflect.Value, loc string) (assigned bool, err error) {
+	if d.Kind() != reflect.Slice {
+		return false, nil
+	}
+	if !d.CanSet() {
+		return false, errNoSetValue(loc)
+	}
+	limit := m.NumericIndexLimit
+	if limit <= 0 {
+		limit = DefaultNumericIndexLimit
+	}
+	indices := make([]int, 0, s.Len())
+	keys := make(map[int]reflect.Value)
+	for _, key := range s.MapKeys() {
+		k := UnwrapInterface(key)
+		index := -1
+		switch TypeClass(k.Kind()) {
+		case StringClass:
+			if n, e := strconv.ParseInt(k.String(), 10, 64); e == nil && n <= int64(limit) {
+				index = int(n)
+			}
+		case IntClass:
+			if n := k.Int(); n <= int64(limit) {
+				index = int(n)
+			}
+		case UintClass:
+			if n := k.Uint(); n <= uint64(limit) {
+				index = int(n)
+			}
+		}
+		if index < 0 {
+			return false, &InvalidKeyError{Loc: m.locExp(loc, fmt.Sprintf("%v", k)), Key: fmt.Sprintf("%v", k)}
+		}
+		indices = append(indices, index)
+		keys[index] = key
+	}
+	sort.Ints(indices)
+	size := 0
+	if len(indices) > 0 {
+		size = indices[len(indices)-1] + 1
+	}
+	v := reflect.MakeSlice(d.Type(), size, size)
+	for _, index := range indices {
+		if _, err = m.assignValue(v.Index(index), s.MapIndex(keys[index]), m.locExp(loc, strconv.Itoa(index))); err != nil {
+			return false, err
+		}
+	}
+	d.Set(v)
+	return true, nil
+}
+
+func (m *Mapper) assignSliceElemsParallel(v, s reflect.Value, loc string) (assigned bool, err error) {
+	workers := runtime.GOMAXPROCS(0)
+	if workers > s.Len() {
+		workers = s.Len()
+	}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	// the errors are kept by index, and aggregated in the index order
+	// instead of the order the elements complete
+	elemErrs := make([]error, s.Len())
+	indices := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				a, e := m.assignValue(v.Index(i), s.Index(i), m.locExp(loc, strconv.Itoa(i)))
+				elemErrs[i] = e
+				if e == nil && a {
+					mutex.Lock()
+					assigned = true
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+	for i := 0; i < s.Len(); i++ {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	errs := &errors.AggregatedError{}
+	errs.AddMany(elemErrs...)
+	if err = errs.Aggregate(); err != nil {
+		return false, err
+	}
+	return
+}
+
 func makeMap(d reflect.Value, loc string) error {
 	if d.IsNil() {
 		if !d.CanSet() {
@@ -362,12 +1284,48 @@ func makeMap(d reflect.Value, loc string) error {
 	return nil
 }
 
+// scalarConvertible determines if a scalar type may be assigned to another
+// scalar type, including the conversions enabled by the options
+func (m *Mapper) scalarConvertible(from, to reflect.Type) bool {
+	return m.ValueTransform != nil || TypeCompatibility(from, to) != Incompatible ||
+		m.optionConversion(from, to) != nil
+}
+
+// optionConversion returns the conversion enabled by the options from a
+// scalar type to another, or nil if the options enable none.
+// It's the dispatch of assignToOther, so scalarConvertible never disagrees
+// with the conversions actually performed
+func (m *Mapper) optionConversion(from, to reflect.Type) func(d, s reflect.Value, loc string) (bool, error) {
+	fc, tc := TypeClass(from.Kind()), TypeClass(to.Kind())
+	switch {
+	case m.ParseBoolStrings && fc == StringClass && to.Kind() == reflect.Bool:
+		return m.assignBoolString
+	case m.FloatToInt != FloatToIntReject && fc == FloatClass && (tc == IntClass || tc == UintClass):
+		return m.assignFloatToInt
+	case m.ComplexFormat == ComplexString && fc == StringClass && tc == ComplexClass:
+		return m.assignComplexString
+	}
+	return nil
+}
+
 func (m *Mapper) assignToMap(d, s reflect.Value, loc string) (assigned bool, err error) {
 	switch TypeClass(s.Kind()) {
 	case MapClass:
-		convFn := TypeConverterFactory(s.Type().Key(), d.Type().Key())
+		convFn := keyConverterFactory(s.Type().Key(), d.Type().Key())
+		// pointer keys are dereferenced if not convertible as pointers
+		derefKeys := false
+		if keyType := s.Type().Key(); convFn == nil && keyType.Kind() == reflect.Ptr {
+			for keyType.Kind() == reflect.Ptr {
+				keyType = keyType.Elem()
+			}
+			convFn, derefKeys = keyConverterFactory(keyType, d.Type().Key()), true
+		}
 		if convFn == nil {
-			return false, errKeyTypeMismatch(loc)
+			return false, errKeyTypeMismatch(s.Type().Key(), d.Type().Key(), loc)
+		}
+		if sElem, dElem := s.Type().Elem(), d.Type().Elem(); IsScalarType(sElem) && IsScalarType(dElem) &&
+			!m.scalarConvertible(sElem, dElem) {
+			return false, errElemTypeMismatch(sElem, dElem, loc)
 		}
 
 		if err = makeMap(d, loc); err != nil {
@@ -377,64 +1335,135 @@ func (m *Mapper) assignToMap(d, s reflect.Value, loc string) (assigned bool, err
 		if len(keys) > 0 {
 			elemType := d.Type().Elem()
 			for _, key := range keys {
+				sval := s.MapIndex(key)
+				if derefKeys {
+					if key = UnwrapPtr(key); !key.IsValid() {
+						return false, errKeyTypeMismatch(s.Type().Key(), d.Type().Key(), m.locExp(loc, "nil"))
+					}
+				}
 				cvKey := convFn(key)
 				if !cvKey.IsValid() {
-					return false, errKeyTypeMismatch(locExp(loc, key.String()))
+					return false, errKeyTypeMismatch(key.Type(), d.Type().Key(), m.locExp(loc, key.String()))
+				}
+				valLoc := m.locExp(loc, UnwrapPtr(key).String())
+				if validate, ok := m.KeyValidators[cvKey.Type()]; ok && !validate(cvKey) {
+					return false, &InvalidKeyError{Loc: valLoc, Key: fmt.Sprintf("%v", cvKey.Interface())}
 				}
 				val := d.MapIndex(cvKey)
-				sval := s.MapIndex(key)
-				valLoc := locExp(loc, key.String())
 				valAssigned, e := m.tryMergeContainers(val, sval, valLoc)
 				if e != nil {
 					return false, e
 				}
+				if !valAssigned && m.MergeStrategy != SourceWins && UnwrapAny(val).IsValid() {
+					if valAssigned, err = m.resolveConflict(val, sval, valLoc); err != nil {
+						return false, err
+					}
+				}
 				if !valAssigned {
+					if v, ok := m.scalarMapElem(elemType, sval, valLoc); ok {
+						d.SetMapIndex(cvKey, v)
+						continue
+					}
 					val = reflect.New(elemType).Elem()
 					if _, err = m.assignValue(val, sval, valLoc); err != nil {
 						return
 					}
+					if err = m.assignKeyFields(val, key, valLoc); err != nil {
+						return false, err
+					}
 					d.SetMapIndex(cvKey, val)
 				}
 			}
 		}
 		assigned = true
 	case StructClass:
+		if data, ok := toMapData(s); ok {
+			return m.assignValue(d, reflect.ValueOf(data), loc)
+		}
 		if d.Type().Elem().Kind() != reflect.Interface {
 			return
 		}
 		convFn := TypeConverterFactory(StringType, d.Type().Key())
 		if convFn == nil {
-			return false, errKeyTypeMismatch(loc)
+			return false, errKeyTypeMismatch(StringType, d.Type().Key(), loc)
 		}
 		if err := makeMap(d, loc); err != nil {
 			return false, err
 		}
-		errs := make(map[string]*structAssignErr)
-		m.assignStructToMap(d, s, loc, convFn, errs)
-		for _, e := range errs {
-			if len(e.errs) > 0 && e.succeeded == 0 {
-				return false, e.errs[0]
-			}
+		errs := newStructAssignErrs()
+		m.assignStructToMap(d, s, loc, convFn, errs, 0, m.promotedDepths(s.Type()), nil)
+		if err = errs.first(); err != nil {
+			return false, err
 		}
 		assigned = true
 	}
 	return
 }
 
+// assignKeyFields injects the map key into the fields tagged with key,
+// when the map element is a struct or a pointer to a struct
+func (m *Mapper) assignKeyFields(v, key reflect.Value, loc string) error {
+	v = UnwrapPtr(v)
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if info := m.ParseField(field); info.Exported && info.KeyField {
+			if _, err := m.assignValue(v.Field(i), key, m.locField(loc, field, info)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (m *Mapper) assignToStruct(d, s reflect.Value, loc string) (assigned bool, err error) {
 	if !d.CanSet() {
 		return false, errNoSetValue(loc)
 	}
+	if receiver, ok := d.Addr().Interface().(ValueReceiver); ok && !s.Type().AssignableTo(d.Type()) {
+		return m.assignToReceiver(receiver, s, loc)
+	}
 	switch TypeClass(s.Kind()) {
 	case StructClass:
 		if s.Type().AssignableTo(d.Type()) {
 			d.Set(s)
+			if m.NeverAlias {
+				if err = m.copyStructFields(d, s, loc); err != nil {
+					return false, err
+				}
+			}
 			assigned = true
+		} else if !isTextStruct(s.Type()) && !isTextStruct(d.Type()) {
+			return m.assignStructToStruct(d, s, loc)
 		}
+	case StringClass:
+		if d.Type() == timeType || reflect.PtrTo(d.Type()).Implements(textUnmarshalerType) {
+			return m.unmarshalText(d, s, loc)
+		}
+		return m.assignToWildcard(d, s, loc)
 	case MapClass:
+		if loader, ok := d.Addr().Interface().(MapDataLoader); ok {
+			data := make(map[string]interface{})
+			if _, err = m.assignValue(reflect.ValueOf(data), s, loc); err != nil {
+				return false, err
+			}
+			if err = loader.FromMapData(data); err != nil {
+				return false, errLifecycle(err, loc)
+			}
+			return true, nil
+		}
 		convFn := TypeConverterFactory(s.Type().Key(), StringType)
 		if convFn != nil {
-			errs := make(map[string]*structAssignErr)
+			// zeroed before BeforeMap, which may set up the defaults
+			if m.EmptyMapResetsStruct && s.Len() == 0 {
+				d.Set(reflect.Zero(d.Type()))
+			}
+			if err = callBeforeMap(d, loc); err != nil {
+				return false, err
+			}
+			errs := newStructAssignErrs()
 			keys := make(map[string]*mapKeyAssign)
 			for _, key := range s.MapKeys() {
 				cvKey := convFn(key)
@@ -442,10 +1471,13 @@ func (m *Mapper) assignToStruct(d, s reflect.Value, loc string) (assigned bool,
 					keys[cvKey.String()] = &mapKeyAssign{key: key}
 				}
 			}
-			m.assignMapToStruct(d, s, loc, keys, errs)
-			for _, e := range errs {
-				if len(e.errs) > 0 && e.succeeded == 0 {
-					return false, e.errs[0]
+			m.assignMapToStruct(d, s, loc, keys, errs, 0, m.promotedDepths(d.Type()))
+			if err = errs.first(); err != nil {
+				return false, err
+			}
+			if m.UseSetters {
+				if err = m.assignSetters(d, s, loc, keys); err != nil {
+					return false, err
 				}
 			}
 			unassignedCnt := 0
@@ -455,55 +1487,49 @@ func (m *Mapper) assignToStruct(d, s reflect.Value, loc string) (assigned bool,
 				}
 			}
 			if unassignedCnt > 0 {
-				// some unassigned keys left, looking for a wildcard map
-				for i := 0; i < d.NumField(); i++ {
-					field := d.Type().Field(i)
-					info := m.ParseField(field)
-					// looking for a wildcard map
-					if !info.Wildcard || field.Type.Kind() != reflect.Map {
-						continue
-					}
-					// map key/value convertible
-					keyConvFn := TypeConverterFactory(s.Type().Key(), field.Type.Key())
-					valConvFn := TypeConverterFactory(s.Type().Elem(), field.Type.Elem())
-					if keyConvFn == nil || valConvFn == nil {
-						continue
-					}
-					m := d.Field(i)
-					if m.IsNil() {
-						m.Set(reflect.MakeMap(field.Type))
-					}
-					for _, mka := range keys {
-						if mka.assigned {
-							continue
-						}
-						cvKey := keyConvFn(mka.key)
-						cvVal := valConvFn(s.MapIndex(mka.key))
-						if !cvKey.IsValid() || !cvVal.IsValid() {
-							continue
-						}
-						m.SetMapIndex(cvKey, cvVal)
-					}
-					break
-				}
+				// some unassigned keys left, looking for wildcard maps
+				m.assignLeftoverKeys(d, s, keys)
+			}
+			if m.report != nil {
+				m.reportUnknown(keys, loc)
+			}
+			if err = callAfterMap(d, loc); err != nil {
+				return false, err
 			}
 			assigned = true
 		}
+	case SliceClass:
+		if assigned, err = m.assignIndexedFields(d, s, loc); assigned || err != nil {
+			return
+		}
+		return m.assignToWildcard(d, s, loc)
 	default:
-		for i := 0; i < d.NumField(); i++ {
-			field := d.Type().Field(i)
-			info := m.ParseField(field)
-			if info.Wildcard {
-				t := field.Type
-				for t.Kind() == reflect.Ptr {
-					t = t.Elem()
-				}
-				convFn := TypeConverterFactory(s.Type(), t)
-				if convFn != nil {
-					convVal := convFn(s)
-					if convVal.IsValid() {
-						return m.assignValue(d.Field(i), convFn(s), locExp(loc, field.Name))
+		return m.assignToWildcard(d, s, loc)
+	}
+	return
+}
+
+// assignToWildcard assigns a non-map value to the first wildcard field
+// in declaration order the value converts to, or to all such fields
+// with WildcardAll
+func (m *Mapper) assignToWildcard(d, s reflect.Value, loc string) (assigned bool, err error) {
+	for i := 0; i < d.NumField(); i++ {
+		field := d.Type().Field(i)
+		info := m.ParseField(field)
+		if info.Wildcard {
+			t := field.Type
+			for t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+			convFn := TypeConverterFactory(s.Type(), t)
+			if convFn != nil {
+				convVal := convFn(s)
+				if convVal.IsValid() {
+					a, err := m.assignValue(d.Field(i), convVal, m.locField(loc, field, info))
+					if err != nil || !m.WildcardAll {
+						return a, err
 					}
+					assigned = assigned || a
 				}
 			}
 		}
@@ -511,70 +1537,483 @@ func (m *Mapper) assignToStruct(d, s reflect.Value, loc string) (assigned bool,
 	return
 }
 
+// assignIndexedFields assigns the elements of a slice to the fields
+// tagged with numeric indices, e.g. `json:"0"`, and the fields beyond
+// the length of the slice are left untouched. It's not assigned if the
+// struct has no indexed fields
+func (m *Mapper) assignIndexedFields(d, s reflect.Value, loc string) (assigned bool, err error) {
+	for i := 0; i < d.NumField(); i++ {
+		field := d.Type().Field(i)
+		info := m.ParseField(field)
+		if !info.Exported || info.Ignore {
+			continue
+		}
+		index, e := strconv.Atoi(info.MapName)
+		if e != nil || index < 0 {
+			continue
+		}
+		if !assigned {
+			if err = callBeforeMap(d, loc); err != nil {
+				return false, err
+			}
+			assigned = true
+		}
+		if index < s.Len() {
+			if _, err = m.assignValue(d.Field(i), s.Index(index), m.locField(loc, field, info)); err != nil {
+				return false, err
+			}
+		}
+	}
+	if assigned {
+		if err = callAfterMap(d, loc); err != nil {
+			return false, err
+		}
+	}
+	return
+}
+
+// assignToReceiver maps the source into the value wrapped by ValueReceiver
+func (m *Mapper) assignToReceiver(receiver ValueReceiver, s reflect.Value, loc string) (assigned bool, err error) {
+	p := reflect.ValueOf(receiver.ValuePtr())
+	if p.Kind() != reflect.Ptr || p.IsNil() {
+		return false, errNoSetValue(loc)
+	}
+	if assigned, err = m.assignValue(p.Elem(), s, loc); err == nil && assigned {
+		receiver.ValueReceived()
+	}
+	return
+}
+
+type wildcardMap struct {
+	field     reflect.Value
+	pattern   string
+	keyConvFn TypeConverter
+	valConvFn TypeConverter
+}
+
+// assignLeftoverKeys distributes the unassigned keys into wildcard map
+// fields. A key goes to the first patterned wildcard (e.g. "x_*") it
+// matches, otherwise to the first catch-all wildcard ("*").
+// Keys matching neither are ignored, like keys without a wildcard
+func (m *Mapper) assignLeftoverKeys(d, s reflect.Value, keys map[string]*mapKeyAssign) {
+	var patterned []*wildcardMap
+	var catchAll, embedded *wildcardMap
+	for i := 0; i < d.NumField(); i++ {
+		field := d.Type().Field(i)
+		info := m.ParseField(field)
+		// an embedded map type is an implicit wildcard map,
+		// unless there's an explicit one
+		implicit := field.Anonymous && info.Exported
+		// looking for a wildcard map
+		if (!info.Wildcard && info.Pattern == "" && !implicit) || field.Type.Kind() != reflect.Map {
+			continue
+		}
+		// map key/value convertible
+		keyConvFn := TypeConverterFactory(s.Type().Key(), field.Type.Key())
+		valConvFn := TypeConverterFactory(s.Type().Elem(), field.Type.Elem())
+		if keyConvFn == nil || valConvFn == nil {
+			continue
+		}
+		w := &wildcardMap{field: d.Field(i), pattern: info.Pattern, keyConvFn: keyConvFn, valConvFn: valConvFn}
+		if info.Wildcard {
+			if catchAll == nil {
+				catchAll = w
+			}
+		} else if implicit {
+			if embedded == nil {
+				embedded = w
+			}
+		} else {
+			patterned = append(patterned, w)
+		}
+	}
+	if catchAll == nil {
+		catchAll = embedded
+	}
+	// distribute in the order of sorted keys to be independent of
+	// the map iteration order
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		mka := keys[name]
+		if mka.assigned {
+			continue
+		}
+		w := catchAll
+		for _, p := range patterned {
+			if matched, _ := path.Match(p.pattern, name); matched {
+				w = p
+				break
+			}
+		}
+		if w == nil {
+			continue
+		}
+		if w.field.IsNil() {
+			w.field.Set(reflect.MakeMap(w.field.Type()))
+		}
+		cvKey := w.keyConvFn(mka.key)
+		cvVal := w.valConvFn(s.MapIndex(mka.key))
+		if !cvKey.IsValid() || !cvVal.IsValid() {
+			continue
+		}
+		w.field.SetMapIndex(cvKey, cvVal)
+		mka.assigned = true
+	}
+}
+
+// copyStructFields re-assigns the exported fields of d from s after d is
+// set to s, so the pointers are allocated again. Unexported fields can't be
+// set by reflection and keep the values copied by d.Set, so the pointers
+// held by them stay shared with s
+func (m *Mapper) copyStructFields(d, s reflect.Value, loc string) error {
+	for i := 0; i < d.NumField(); i++ {
+		field := d.Field(i)
+		if !field.CanSet() {
+			continue
+		}
+		field.Set(reflect.Zero(field.Type()))
+		sf := d.Type().Field(i)
+		if _, err := m.assignValue(field, s.Field(i), m.locField(loc, sf, m.ParseField(sf))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (m *Mapper) assignToOther(d, s reflect.Value, loc string) (assigned bool, err error) {
+	if m.ValueTransform != nil {
+		if v, ok := m.ValueTransform(d.Type(), s, loc); ok {
+			if !v.IsValid() {
+				return true, nil
+			}
+			s = v
+		}
+	}
+	if sv := UnwrapInterface(s); sv.IsValid() {
+		if conv := m.optionConversion(sv.Type(), d.Type()); conv != nil {
+			return conv(d, sv, loc)
+		}
+	}
+	if !m.noFastPath() && d.CanSet() && assignScalarFast(d, s) {
+		if s.Type() != d.Type() {
+			m.traceConvert(s.Type(), d.Type(), loc)
+		}
+		return true, nil
+	}
 	switch TypeCompatibility(s.Type(), d.Type()) {
 	case Assignable:
 		if !d.CanSet() {
 			return false, errNoSetValue(loc)
 		}
+		if err = m.checkFinite(s, loc); err != nil {
+			return false, err
+		}
 		d.Set(s)
 		assigned = true
 	case Convertible:
 		if !d.CanSet() {
 			return false, errNoSetValue(loc)
 		}
-		d.Set(s.Convert(d.Type()))
+		v := s.Convert(d.Type())
+		if err = m.checkFinite(v, loc); err != nil {
+			return false, err
+		}
+		d.Set(v)
+		m.traceConvert(s.Type(), d.Type(), loc)
 		assigned = true
 	}
 	return
 }
 
+// assignToFunc assigns a function of the same type, or adapts a function
+// of a different signature with FuncAdapter
+func (m *Mapper) assignToFunc(d, s reflect.Value, loc string) (assigned bool, err error) {
+	if s.Kind() != reflect.Func || TypeCompatibility(s.Type(), d.Type()) != Incompatible {
+		return m.assignToOther(d, s, loc)
+	}
+	if !d.CanSet() {
+		return false, errNoSetValue(loc)
+	}
+	if m.FuncAdapter != nil {
+		if convFn := m.FuncAdapter(d.Type(), s.Type()); convFn != nil {
+			if v := convFn(s); v.IsValid() && v.Type().AssignableTo(d.Type()) {
+				d.Set(v)
+				m.traceConvert(s.Type(), d.Type(), loc)
+				return true, nil
+			}
+		}
+	}
+	return false, &FuncSignatureError{Loc: loc, From: s.Type(), To: d.Type()}
+}
+
+// FloatToIntMode defines how floats are mapped to integer destinations
+type FloatToIntMode int
+
+// Float to int modes
+const (
+	// FloatToIntReject fails the mapping as incompatible types
+	FloatToIntReject FloatToIntMode = iota
+	// FloatToIntTruncate truncates the fraction toward zero
+	FloatToIntTruncate
+	// FloatToIntRound rounds half away from zero
+	FloatToIntRound
+	// FloatToIntExact only accepts floats without a fraction, e.g. 2.0
+	FloatToIntExact
+)
+
+// assignFloatToInt converts a float to an integer by FloatToInt, and fails
+// if the result overflows the destination
+func (m *Mapper) assignFloatToInt(d, s reflect.Value, loc string) (bool, error) {
+	if !d.CanSet() {
+		return false, errNoSetValue(loc)
+	}
+	f := s.Float()
+	var n float64
+	switch m.FloatToInt {
+	case FloatToIntRound:
+		n = math.Round(f)
+	case FloatToIntExact:
+		if n = math.Trunc(f); n != f {
+			return false, &FloatToIntError{Loc: loc, Value: f, Type: d.Type()}
+		}
+	default:
+		n = math.Trunc(f)
+	}
+	if TypeClass(d.Kind()) == IntClass {
+		if math.IsNaN(n) || n < math.MinInt64 || n >= -math.MinInt64 || d.OverflowInt(int64(n)) {
+			return false, &FloatToIntError{Loc: loc, Value: f, Type: d.Type()}
+		}
+		d.SetInt(int64(n))
+	} else {
+		if math.IsNaN(n) || n < 0 || n >= 2*-math.MinInt64 || d.OverflowUint(uint64(n)) {
+			return false, &FloatToIntError{Loc: loc, Value: f, Type: d.Type()}
+		}
+		d.SetUint(uint64(n))
+	}
+	m.traceConvert(s.Type(), d.Type(), loc)
+	return true, nil
+}
+
+// checkFinite rejects Inf and NaN if RejectNonFinite is set,
+// including the overflow from conversion, e.g. float64 to float32
+func (m *Mapper) checkFinite(v reflect.Value, loc string) error {
+	if m.RejectNonFinite && TypeClass(v.Kind()) == FloatClass {
+		if f := v.Float(); math.IsInf(f, 0) || math.IsNaN(f) {
+			return &NonFiniteError{Loc: loc, Value: f}
+		}
+	}
+	return nil
+}
+
+// DefaultBoolStrings contains the strings accepted by strconv.ParseBool,
+// plus yes/no and on/off
+var DefaultBoolStrings = map[string]bool{
+	"1": true, "t": true, "true": true, "yes": true, "on": true,
+	"0": false, "f": false, "false": false, "no": false, "off": false,
+}
+
+// assignBoolString parses a string into a bool destination
+func (m *Mapper) assignBoolString(d, s reflect.Value, loc string) (bool, error) {
+	if !d.CanSet() {
+		return false, errNoSetValue(loc)
+	}
+	boolStrings := m.BoolStrings
+	if boolStrings == nil {
+		boolStrings = DefaultBoolStrings
+	}
+	b, ok := boolStrings[strings.ToLower(s.String())]
+	if !ok {
+		return false, &BoolStringError{Loc: loc, Value: s.String()}
+	}
+	d.SetBool(b)
+	m.traceConvert(s.Type(), d.Type(), loc)
+	return true, nil
+}
+
 type structAssignErr struct {
 	succeeded int
 	errs      []error
 }
 
+// structAssignErrs collects errors per map name, in the order the
+// names are first visited, i.e. the declaration order of fields
+type structAssignErrs struct {
+	byName map[string]*structAssignErr
+	names  []string
+}
+
+func newStructAssignErrs() *structAssignErrs {
+	return &structAssignErrs{byName: make(map[string]*structAssignErr)}
+}
+
+func (e *structAssignErrs) get(name string) *structAssignErr {
+	assignErr := e.byName[name]
+	if assignErr == nil {
+		assignErr = &structAssignErr{}
+		e.byName[name] = assignErr
+		e.names = append(e.names, name)
+	}
+	return assignErr
+}
+
+// first returns the first error of the first name which failed
+// on all fields
+func (e *structAssignErrs) first() error {
+	for _, name := range e.names {
+		if assignErr := e.byName[name]; len(assignErr.errs) > 0 && assignErr.succeeded == 0 {
+			return assignErr.errs[0]
+		}
+	}
+	return nil
+}
+
 type mapKeyAssign struct {
 	key      reflect.Value
 	assigned bool
+	// matched is true if a field matches the key, even if not assigned
+	matched bool
+}
+
+// matchKey finds the source key of the map name. If there's no exact
+// match and the field is case insensitive, the first key in sorted order
+// equal to the map name under case folding is matched
+func (m *Mapper) matchKey(keys map[string]*mapKeyAssign, name string, info *FieldInfo) *mapKeyAssign {
+	if mka, exist := keys[name]; exist || info.CaseSensitive || !(info.CaseInsensitive || m.CaseInsensitive) {
+		return mka
+	}
+	var matched *mapKeyAssign
+	var matchedKey string
+	for key, mka := range keys {
+		if strings.EqualFold(key, name) && (matched == nil || key < matchedKey) {
+			matched, matchedKey = mka, key
+		}
+	}
+	return matched
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// assignSetters calls the setter methods named Set+key, e.g. SetName
+// for key name, for the keys without a matching field
+func (m *Mapper) assignSetters(d, s reflect.Value, loc string, keys map[string]*mapKeyAssign) error {
+	if !d.CanAddr() {
+		return nil
+	}
+	names := make([]string, 0, len(keys))
+	for name, mka := range keys {
+		if !mka.assigned && name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		methodName := "Set" + strings.ToUpper(name[:1]) + name[1:]
+		method := d.Addr().MethodByName(methodName)
+		if !method.IsValid() {
+			continue
+		}
+		t := method.Type()
+		if t.NumIn() != 1 || t.NumOut() > 1 || (t.NumOut() == 1 && t.Out(0) != errorType) {
+			continue
+		}
+		setterLoc := m.locExp(loc, methodName)
+		arg := reflect.New(t.In(0)).Elem()
+		if _, err := m.assignValue(arg, s.MapIndex(keys[name].key), setterLoc); err != nil {
+			return err
+		}
+		if out := method.Call([]reflect.Value{arg}); len(out) == 1 && !out[0].IsNil() {
+			return errLifecycle(out[0].Interface().(error), setterLoc)
+		}
+		keys[name].assigned = true
+	}
+	return nil
 }
 
-func (m *Mapper) assignStructToMap(d, s reflect.Value, loc string, convFn TypeConverter, errs map[string]*structAssignErr) {
+func (m *Mapper) assignStructToMap(d, s reflect.Value, loc string, convFn TypeConverter, errs *structAssignErrs,
+	depth int, depths map[string]int, only map[string]bool) {
+	if names, ok := m.OnlyFields[s.Type()]; ok && only == nil {
+		only = make(map[string]bool)
+		for _, name := range names {
+			only[name] = true
+		}
+	}
 	for i := 0; i < s.NumField(); i++ {
 		field := s.Type().Field(i)
 		info := m.ParseField(field)
+		if field.Anonymous && field.Type.Kind() == reflect.Interface {
+			continue
+		}
+		embedded := (field.Anonymous || info.Squash) && field.Type.Kind() == reflect.Struct
+		if only != nil && !embedded && !only[info.MapName] {
+			continue
+		}
 		var err error
 		var assignedVal reflect.Value
-		if field.Type.Kind() == reflect.Struct {
-			if field.Anonymous || info.Squash {
-				m.assignStructToMap(d, s.Field(i), locExp(loc, field.Name), convFn, errs)
+		if info.Converter != "" && !embedded {
+			if v := s.Field(i); info.Exported && !info.Ignore && depths[info.MapName] >= depth &&
+				!m.omitField(info, v) {
+				assignedVal, err = m.convertField(v, d.Type().Elem(), info, m.locField(loc, field, info))
+			}
+		} else if field.Type.Kind() == reflect.Struct && (embedded || !isTextStruct(field.Type)) {
+			if embedded {
+				m.assignStructToMap(d, s.Field(i), m.locField(loc, field, info), convFn, errs,
+					embedDepth(field, depth), depths, only)
+			} else if info.Exported && !info.Ignore && info.MapName != "" && depths[info.MapName] < depth {
+				// shadowed by a shallower field of the same name
+			} else if m.omitField(info, s.Field(i)) {
+				continue
+			} else if data, ok := toMapData(s.Field(i)); ok {
+				assignedVal = reflect.ValueOf(data)
 			} else {
 				assignedVal = reflect.MakeMap(reflect.MapOf(StringType, InterfaceType))
-				m.assignStructToMap(assignedVal, s.Field(i), locExp(loc, field.Name), convFn, errs)
+				m.assignStructToMap(assignedVal, s.Field(i), m.locField(loc, field, info), convFn, errs,
+					0, m.promotedDepths(field.Type), nil)
+				// like IsEmpty, a struct is never empty, but with omitempty
+				// the key is left out if all the fields are omitted
+				if info.OmitEmpty && assignedVal.Len() == 0 {
+					continue
+				}
 			}
-		} else if info.Exported && !info.Ignore && info.MapName != "" {
+		} else if info.Exported && !info.Ignore && info.MapName != "" && depths[info.MapName] >= depth {
 			v := s.Field(i)
-			if !v.IsValid() || (IsEmpty(v) && info.OmitEmpty) {
+			if !v.IsValid() || m.omitField(info, v) {
 				continue
 			}
-			var val interface{}
-			pv := reflect.ValueOf(&val)
-			_, err = m.assignValue(pv.Elem(), v, locExp(loc, field.Name))
-			assignedVal = pv.Elem()
+			if m.EmitNulls && isNil(v) {
+				assignedVal = reflect.Zero(d.Type().Elem())
+			} else if isTextStruct(field.Type) && v.CanInterface() {
+				assignedVal, err = m.marshalText(v, m.locField(loc, field, info))
+			} else {
+				var val interface{}
+				pv := reflect.ValueOf(&val)
+				_, err = m.assignValue(pv.Elem(), v, m.locField(loc, field, info))
+				assignedVal = m.formatBytes(m.formatComplex(pv.Elem()))
+				if err == nil {
+					assignedVal, err = m.renderMapKeys(assignedVal, m.locField(loc, field, info))
+				}
+			}
+			if err == nil && m.AnnotateTypes && field.Type.Kind() == reflect.Interface && !v.IsNil() {
+				assignedVal, err = m.annotateType(v.Elem(), assignedVal, m.locField(loc, field, info))
+			}
+			if err == nil && info.AsType != "" {
+				if assignedVal, err = convertAsType(v, info.AsType, m.locField(loc, field, info)); err != nil {
+					assignedVal = reflect.Value{}
+				}
+			}
 		}
 		if assignedVal.IsValid() {
 			key := convFn(reflect.ValueOf(info.MapName))
 			if key.IsValid() {
 				d.SetMapIndex(key, assignedVal)
 			} else {
-				err = errKeyTypeMismatch(locExp(loc, field.Name))
+				err = errKeyTypeMismatch(StringType, d.Type().Key(), m.locField(loc, field, info))
 			}
 		}
-		assignErr := errs[info.MapName]
-		if assignErr == nil {
-			assignErr = &structAssignErr{}
-			errs[info.MapName] = assignErr
-		}
+		assignErr := errs.get(info.MapName)
 		if err != nil {
 			assignErr.errs = append(assignErr.errs, err)
 		} else {
@@ -583,24 +2022,222 @@ func (m *Mapper) assignStructToMap(d, s reflect.Value, loc string, convFn TypeCo
 	}
 }
 
-func (m *Mapper) assignMapToStruct(d, s reflect.Value, loc string, keys map[string]*mapKeyAssign, errs map[string]*structAssignErr) {
+// annotateType wraps the value of an interface field with the name of
+// the concrete type, and structs are mapped into maps
+func (m *Mapper) annotateType(v, val reflect.Value, loc string) (reflect.Value, error) {
+	typeKey, valueKey := m.TypeKey, m.TypeValueKey
+	if typeKey == "" {
+		typeKey = "@type"
+	}
+	if valueKey == "" {
+		valueKey = "value"
+	}
+	if u := UnwrapPtr(v); u.Kind() == reflect.Struct && !isTextStruct(u.Type()) {
+		out := make(map[string]interface{})
+		if _, err := m.assignValue(reflect.ValueOf(out), u, loc); err != nil {
+			return reflect.Value{}, err
+		}
+		val = reflect.ValueOf(out)
+	}
+	return reflect.ValueOf(map[string]interface{}{
+		typeKey:  v.Type().String(),
+		valueKey: val.Interface(),
+	}), nil
+}
+
+// renderMapKeys converts a map whose keys are rendered by
+// keyConverterFactory into map[string]interface{} for map output
+func (m *Mapper) renderMapKeys(v reflect.Value, loc string) (reflect.Value, error) {
+	u := UnwrapInterface(v)
+	if u.Kind() != reflect.Map || TypeConverterFactory(u.Type().Key(), StringType) != nil ||
+		keyConverterFactory(u.Type().Key(), StringType) == nil {
+		return v, nil
+	}
+	var out interface{} = make(map[string]interface{})
+	if _, err := m.assignValue(reflect.ValueOf(out), u, loc); err != nil {
+		return reflect.Value{}, err
+	}
+	return reflect.ValueOf(&out).Elem(), nil
+}
+
+// convertField converts the value with the FieldConverter of the field
+func (m *Mapper) convertField(v reflect.Value, to reflect.Type, info *FieldInfo, loc string) (reflect.Value, error) {
+	conv, ok := m.FieldConverters[info.Converter]
+	if !ok {
+		return reflect.Value{}, &ConverterError{Loc: loc, Name: info.Converter, Err: errConverterNotFound}
+	}
+	out, err := conv(v, loc)
+	if err != nil {
+		return reflect.Value{}, &ConverterError{Loc: loc, Name: info.Converter, Err: err}
+	}
+	m.traceConvert(v.Type(), to, loc)
+	return out, nil
+}
+
+// isNil determines if the value is a nil pointer, interface, slice or map
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+		return v.IsNil()
+	}
+	return false
+}
+
+// isTextStruct determines if a struct is rendered as a string instead of
+// a nested map, when it's time.Time or implements encoding.TextMarshaler
+func isTextStruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct &&
+		(t == timeType || t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType))
+}
+
+// marshalText renders time.Time with TimeLayout, and other structs
+// with MarshalText
+func (m *Mapper) marshalText(v reflect.Value, loc string) (reflect.Value, error) {
+	if v.Type() == timeType {
+		layout := m.TimeLayout
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		return reflect.ValueOf(v.Interface().(time.Time).Format(layout)), nil
+	}
+	if !v.CanAddr() {
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		v = p.Elem()
+	}
+	text, err := v.Addr().Interface().(encoding.TextMarshaler).MarshalText()
+	if err != nil {
+		return reflect.Value{}, errMarshal(err, loc)
+	}
+	return reflect.ValueOf(string(text)), nil
+}
+
+// unmarshalText parses a string into time.Time with TimeLayout, and into
+// other structs with UnmarshalText, the reverse of marshalText
+func (m *Mapper) unmarshalText(d, s reflect.Value, loc string) (bool, error) {
+	if d.Type() == timeType {
+		layout := m.TimeLayout
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		tm, err := time.Parse(layout, s.String())
+		if err != nil {
+			return false, errMarshal(err, loc)
+		}
+		d.Set(reflect.ValueOf(tm))
+		return true, nil
+	}
+	if err := d.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s.String())); err != nil {
+		return false, errMarshal(err, loc)
+	}
+	return true, nil
+}
+
+// convertAsType converts the value to the type specified by astype
+// and returns the result wrapped in an interface
+func convertAsType(v reflect.Value, asType string, loc string) (reflect.Value, error) {
+	v = UnwrapAny(v)
+	var out interface{}
+	if v.IsValid() {
+		class := TypeClass(v.Kind())
+		var err error
+		switch asType {
+		case "float64":
+			switch class {
+			case IntClass, UintClass, FloatClass:
+				out = v.Convert(reflect.TypeOf(float64(0))).Interface()
+			case StringClass:
+				out, err = strconv.ParseFloat(v.String(), 64)
+			default:
+				err = errAsType(asType, loc)
+			}
+		case "int64":
+			switch class {
+			case IntClass, UintClass:
+				out = v.Convert(reflect.TypeOf(int64(0))).Interface()
+			case FloatClass:
+				if f := v.Float(); f == float64(int64(f)) {
+					out = int64(f)
+				} else {
+					err = errAsType(asType, loc)
+				}
+			case StringClass:
+				out, err = strconv.ParseInt(v.String(), 10, 64)
+			default:
+				err = errAsType(asType, loc)
+			}
+		case "string":
+			switch class {
+			case BoolClass, IntClass, UintClass, FloatClass, StringClass:
+				out = fmt.Sprint(v.Interface())
+			default:
+				err = errAsType(asType, loc)
+			}
+		case "bool":
+			switch class {
+			case BoolClass:
+				out = v.Bool()
+			case StringClass:
+				out, err = strconv.ParseBool(v.String())
+			default:
+				err = errAsType(asType, loc)
+			}
+		default:
+			err = &AsTypeError{Loc: loc, AsType: asType, Unsupported: true}
+		}
+		if err != nil {
+			if _, ok := err.(*strconv.NumError); ok {
+				err = errAsType(asType, loc)
+			}
+			return reflect.Value{}, err
+		}
+	}
+	return reflect.ValueOf(&out).Elem(), nil
+}
+
+func (m *Mapper) assignMapToStruct(d, s reflect.Value, loc string, keys map[string]*mapKeyAssign, errs *structAssignErrs,
+	depth int, depths map[string]int) {
 	for i := 0; i < d.Type().NumField(); i++ {
 		field := d.Type().Field(i)
 		info := m.ParseField(field)
-		if (field.Anonymous || info.Squash) && field.Type.Kind() == reflect.Struct {
-			m.assignMapToStruct(d.Field(i), s, locExp(loc, field.Name), keys, errs)
-		} else if key := info.MapName; info.Exported && !info.Ignore && key != "" {
-			if mka, exist := keys[key]; !exist {
+		if field.Anonymous && field.Type.Kind() == reflect.Interface {
+			// embedded interfaces have no fields to map
+			continue
+		} else if (field.Anonymous || info.Squash) && field.Type.Kind() == reflect.Struct {
+			m.assignMapToStruct(d.Field(i), s, m.locField(loc, field, info), keys, errs, embedDepth(field, depth), depths)
+		} else if key := info.MapName; info.Exported && !info.Ignore && key != "" && !m.isPreserved(field.Type) {
+			if depths[key] < depth {
+				// shadowed by a shallower field
+				continue
+			} else if mka := m.matchKey(keys, key, info); mka == nil {
+				m.reportField(m.locField(loc, field, info), false, nil)
 				continue
 			} else if mapVal := s.MapIndex(mka.key); !mapVal.IsValid() {
+				m.reportField(m.locField(loc, field, info), false, nil)
 				continue
 			} else {
-				assignErr := errs[key]
-				if assignErr == nil {
-					assignErr = &structAssignErr{}
-					errs[key] = assignErr
+				mka.matched = true
+				m.markPresent(m.locField(loc, field, info))
+				assignErr := errs.get(key)
+				fieldLoc := m.locField(loc, field, info)
+				var assigned bool
+				var err error
+				if fv := UnwrapAny(d.Field(i)); m.MergeStrategy != SourceWins &&
+					fv.IsValid() && !m.isContainer(fv) && !m.isEmpty(fv) {
+					assigned, err = m.resolveConflict(d.Field(i), mapVal, fieldLoc)
+				}
+				if !assigned && err == nil {
+					if info.Converter != "" {
+						var converted reflect.Value
+						if converted, err = m.convertField(mapVal, field.Type, info, fieldLoc); err == nil {
+							assigned, err = m.assignValue(d.Field(i), converted, fieldLoc)
+						}
+					} else if m.assignScalarField(d.Field(i), mapVal) {
+						assigned = true
+					} else {
+						assigned, err = m.assignValue(d.Field(i), mapVal, fieldLoc)
+					}
 				}
-				assigned, err := m.assignValue(d.Field(i), s.MapIndex(mka.key), locExp(loc, field.Name))
 				if err != nil {
 					assignErr.errs = append(assignErr.errs, err)
 				} else {
@@ -609,6 +2246,159 @@ func (m *Mapper) assignMapToStruct(d, s reflect.Value, loc string, keys map[stri
 				if assigned {
 					mka.assigned = true
 				}
+				m.reportField(fieldLoc, assigned, err)
+			}
+		}
+	}
+}
+
+// noFastPath determines if every value must go through assignValue,
+// as it's traced, guarded, checked or transformed
+func (m *Mapper) noFastPath() bool {
+	return m.Tracer != nil || m.ResultTracer != nil || m.Guard != nil || m.RejectNonFinite || m.ValueTransform != nil
+}
+
+// assignScalarField is the fast path of assignValue for a scalar source
+// which is assignable to a scalar field. It's skipped when tracing, as
+// the tracer expects to see every step of the traversal, and when values
+// are checked or transformed
+func (m *Mapper) assignScalarField(d, s reflect.Value) bool {
+	if m.noFastPath() || !d.CanSet() || !IsScalarType(d.Type()) {
+		return false
+	}
+	s = UnwrapInterface(s)
+	if !s.IsValid() || !IsScalarType(s.Type()) || TypeCompatibility(s.Type(), d.Type()) != Assignable {
+		return false
+	}
+	d.Set(s)
+	return true
+}
+
+// assignScalarFast is the fast path of assignToOther between bool, number
+// and string kinds, which sets the destination by kind without computing
+// TypeCompatibility and calling Convert. It only accepts the pairs of
+// kinds compatible by TypeCompatibility, and the results are identical
+func assignScalarFast(d, s reflect.Value) bool {
+	dc, sc := TypeClass(d.Kind()), TypeClass(s.Kind())
+	switch dc {
+	case BoolClass:
+		if sc == BoolClass {
+			d.SetBool(s.Bool())
+			return true
+		}
+	case StringClass:
+		if sc == StringClass {
+			d.SetString(s.String())
+			return true
+		}
+	case IntClass, UintClass:
+		var n uint64
+		switch sc {
+		case IntClass:
+			n = uint64(s.Int())
+		case UintClass:
+			n = s.Uint()
+		default:
+			return false
+		}
+		// the setters truncate the same way as the conversion
+		if dc == IntClass {
+			d.SetInt(int64(n))
+		} else {
+			d.SetUint(n)
+		}
+		return true
+	case FloatClass:
+		var f float64
+		switch sc {
+		case IntClass:
+			if d.Kind() == reflect.Float32 {
+				// rounds once like the conversion, instead of twice via float64
+				f = float64(float32(s.Int()))
+			} else {
+				f = float64(s.Int())
+			}
+		case UintClass:
+			if d.Kind() == reflect.Float32 {
+				f = float64(float32(s.Uint()))
+			} else {
+				f = float64(s.Uint())
+			}
+		case FloatClass:
+			f = s.Float()
+		default:
+			return false
+		}
+		d.SetFloat(f)
+		return true
+	}
+	return false
+}
+
+// scalarMapElem is the fast path of assignToMap for a scalar source
+// element directly assignable or convertible to a scalar element type,
+// which is set into the map without allocating an intermediate value
+func (m *Mapper) scalarMapElem(t reflect.Type, s reflect.Value, loc string) (reflect.Value, bool) {
+	if m.noFastPath() || !IsScalarType(t) {
+		return reflect.Value{}, false
+	}
+	s = UnwrapInterface(s)
+	if !s.IsValid() || !IsScalarType(s.Type()) {
+		return reflect.Value{}, false
+	}
+	switch TypeCompatibility(s.Type(), t) {
+	case Assignable:
+		return s, true
+	case Convertible:
+		m.traceConvert(s.Type(), t, loc)
+		return s.Convert(t), true
+	}
+	return reflect.Value{}, false
+}
+
+// promotedDepths collects the shallowest depth of each map name in
+// the struct type, including fields from anonymous and squashed structs.
+// Like Go field promotion, a shallower field shadows fields with the same
+// map name in anonymous structs. Squashed structs are flattened into the
+// same depth, and fields of the same depth are all mapped
+func (m *Mapper) promotedDepths(t reflect.Type) map[string]int {
+	key := typeTagsKey{t: t, tags: m.tagsKey()}
+	if depths, ok := m.depthsCache.Load(key); ok {
+		return depths.(map[string]int)
+	}
+	depths := make(map[string]int)
+	m.collectDepths(t, 0, depths)
+	m.depthsCache.Store(key, depths)
+	return depths
+}
+
+// typeTagsKey identifies what is derived from a struct type, which depends
+// on Mapper.FieldTags and Mapper.OptionsTag
+type typeTagsKey struct {
+	t    reflect.Type
+	tags string
+}
+
+func (m *Mapper) tagsKey() string {
+	return strings.Join(m.FieldTags, ",") + ";" + m.OptionsTag
+}
+
+func embedDepth(field reflect.StructField, depth int) int {
+	if field.Anonymous {
+		return depth + 1
+	}
+	return depth
+}
+
+func (m *Mapper) collectDepths(t reflect.Type, depth int, depths map[string]int) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		info := m.ParseField(field)
+		if (field.Anonymous || info.Squash) && field.Type.Kind() == reflect.Struct {
+			m.collectDepths(field.Type, embedDepth(field, depth), depths)
+		} else if key := info.MapName; info.Exported && !info.Ignore && key != "" {
+			if d, exist := depths[key]; !exist || depth < d {
+				depths[key] = depth
 			}
 		}
 	}
@@ -626,33 +2416,117 @@ func (m *Mapper) ParseField(f reflect.StructField) *FieldInfo {
 		}
 		for _, tag := range tags {
 			if val := f.Tag.Get(tag); val != "" {
-				vals := strings.Split(val, ",")
-				if vals[0] == "-" {
+				// like encoding/json, only a bare "-" ignores the field,
+				// and "-," names the field "-"
+				if val == "-" {
 					info.Ignore = true
-				} else if vals[0] != "" {
+					break
+				}
+				vals := strings.Split(val, ",")
+				if vals[0] != "" {
 					info.MapName = vals[0]
 					if info.MapName == "*" {
 						info.Wildcard = true
+					} else if strings.ContainsAny(info.MapName, "*?[") {
+						info.Pattern = info.MapName
 					}
 				}
 				for i := 1; i < len(vals); i++ {
-					switch vals[i] {
-					case "squash":
-						info.Squash = true
-					case "omitempty":
-						info.OmitEmpty = true
-					}
+					info.parseOption(vals[i])
 				}
 				break
 			}
 		}
+		if m.OptionsTag != "" {
+			if val := f.Tag.Get(m.OptionsTag); val != "" {
+				for _, opt := range strings.Split(val, ",") {
+					switch opt = strings.TrimSpace(opt); opt {
+					case "-", "ignore":
+						info.Ignore = true
+					case "wildcard":
+						info.Wildcard = true
+						info.MapName = "*"
+						info.Pattern = ""
+					default:
+						info.parseOption(opt)
+					}
+				}
+			}
+		}
 	}
 	return info
 }
 
+// parseOption applies an option following the map name in the tag,
+// or in the tag of Mapper.OptionsTag
+func (info *FieldInfo) parseOption(opt string) {
+	switch opt {
+	case "squash", "inline":
+		info.Squash = true
+	case "omitempty":
+		info.OmitEmpty = true
+	case "keepempty":
+		info.KeepEmpty = true
+	case "key":
+		info.KeyField = true
+	case "ci":
+		info.CaseInsensitive = true
+	case "cs":
+		info.CaseSensitive = true
+	default:
+		if strings.HasPrefix(opt, "astype=") {
+			info.AsType = opt[len("astype="):]
+		} else if strings.HasPrefix(opt, "conv=") {
+			info.Converter = opt[len("conv="):]
+		}
+	}
+}
+
+// MapNameOf returns the map key of the field of the struct type, including
+// promoted fields, and false if the field doesn't map to a key, e.g.
+// it's unexported, ignored, squashed or a wildcard
+func (m *Mapper) MapNameOf(t reflect.Type, goFieldName string) (string, bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return "", false
+	}
+	field, ok := t.FieldByName(goFieldName)
+	if !ok {
+		return "", false
+	}
+	info := m.ParseField(field)
+	if !info.Exported || info.Ignore || info.Squash || info.Wildcard || info.Pattern != "" || info.MapName == "" {
+		return "", false
+	}
+	return info.MapName, true
+}
+
 // MapValue copies values of reflect.Value
 // If the destination is a pointer, the address is assigned
+// A source which is invalid, nil or a nil pointer is a no-op
 func (m *Mapper) MapValue(v, s reflect.Value) error {
+	if v.IsValid() && !v.CanSet() {
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Chan:
+		default:
+			// e.g. reflect.ValueOf(v) of a struct, which is a copy
+			return &UnaddressableError{Type: v.Type()}
+		}
+	}
+	if m.TrackPresence {
+		m.Presence = make(map[string]bool)
+	}
+	if m.SharePointers {
+		m.shared = nil
+	}
+	if m.NeverAlias {
+		m.copies = nil
+	}
+	if !UnwrapAny(s).IsValid() {
+		return nil
+	}
 	_, err := m.assignValue(v, s, "")
 	return err
 }
@@ -662,6 +2536,239 @@ func (m *Mapper) Map(v, s interface{}) error {
 	return m.MapValue(reflect.ValueOf(v), reflect.ValueOf(s))
 }
 
+// Report lists how the fields of structs are mapped from maps by MapReport,
+// including nested structs, and the fields and keys are listed by locs
+type Report struct {
+	// Applied lists the fields which received a value
+	Applied []string
+	// Skipped lists the fields without a source key, or with a null value
+	Skipped []string
+	// Errors contains the errors of fields, including the fields of
+	// multi-mapping which don't accept the value of the source key
+	Errors map[string]error
+	// Unknown lists the source keys which match no field
+	Unknown []string
+}
+
+// MapReport maps s into v like Map, and reports the applied, skipped and
+// errored fields, and unknown keys. The report is returned even with
+// an error, and it contains the fields mapped before the error
+func (m *Mapper) MapReport(v, s interface{}) (*Report, error) {
+	report := &Report{Errors: make(map[string]error)}
+	m.report = report
+	defer func() {
+		m.report = nil
+	}()
+	err := m.Map(v, s)
+	return report, err
+}
+
+func (m *Mapper) reportField(loc string, assigned bool, err error) {
+	if m.report == nil {
+		return
+	}
+	m.reportLock.Lock()
+	defer m.reportLock.Unlock()
+	switch {
+	case err != nil:
+		m.report.Errors[loc] = err
+	case assigned:
+		m.report.Applied = append(m.report.Applied, loc)
+	default:
+		m.report.Skipped = append(m.report.Skipped, loc)
+	}
+}
+
+func (m *Mapper) reportUnknown(keys map[string]*mapKeyAssign, loc string) {
+	var unknown []string
+	for name, mka := range keys {
+		if !mka.assigned && !mka.matched {
+			unknown = append(unknown, m.locExp(loc, name))
+		}
+	}
+	sort.Strings(unknown)
+	m.reportLock.Lock()
+	defer m.reportLock.Unlock()
+	m.report.Unknown = append(m.report.Unknown, unknown...)
+}
+
+// MapWithDefaults copies proto into dst first, and then maps src over
+// it, so the keys absent from src keep the values of proto, and src wins
+// on conflicts. Unless NeverAlias is set, maps and slices of proto may be
+// shared with dst, and modified when src is merged
+func (m *Mapper) MapWithDefaults(dst, src, proto interface{}) error {
+	if err := m.Map(dst, proto); err != nil {
+		return err
+	}
+	return m.Map(dst, src)
+}
+
+// Scan maps the exported fields of a struct source, or the elements of
+// a slice source, into the destination pointers in order, like
+// database/sql Rows.Scan. The number of destinations must match the
+// number of values, and a nil destination skips the value
+func (m *Mapper) Scan(src interface{}, dests ...interface{}) error {
+	s := UnwrapAny(reflect.ValueOf(src))
+	var values []reflect.Value
+	var locs []string
+	switch TypeClass(s.Kind()) {
+	case StructClass:
+		for i := 0; i < s.NumField(); i++ {
+			if field := s.Type().Field(i); m.ParseField(field).Exported {
+				values = append(values, s.Field(i))
+				locs = append(locs, m.locExp("", field.Name))
+			}
+		}
+	case SliceClass:
+		for i := 0; i < s.Len(); i++ {
+			values = append(values, s.Index(i))
+			locs = append(locs, m.locExp("", strconv.Itoa(i)))
+		}
+	case InvalidClass:
+		return &InvalidValueError{Loc: ""}
+	default:
+		return errMismatch(s.Type(), reflect.TypeOf(dests).Elem(), "")
+	}
+	if len(values) != len(dests) {
+		return &ScanCountError{Values: len(values), Dests: len(dests)}
+	}
+	for n, dest := range dests {
+		d := reflect.ValueOf(dest)
+		if !d.IsValid() || (d.Kind() == reflect.Ptr && d.IsNil()) {
+			continue
+		}
+		if _, err := m.assignValue(d, values[n], locs[n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MapTimeout is Map which returns TimeoutError as soon as the mapping
+// takes longer than timeout. The mapping runs in another goroutine, which
+// is asked to stop at the next value it assigns, but keeps running while
+// a callback (e.g. Guard, ValueTransform or a setter) blocks. The
+// destination may be partially written after TimeoutError, and the Mapper
+// stays busy until that goroutine finishes, so it must not be used by
+// other mappings meanwhile. A panic during the mapping is raised again in
+// the caller, unless it happens after TimeoutError is returned
+func (m *Mapper) MapTimeout(timeout time.Duration, v, s interface{}) error {
+	// state is mapRunning until either the mapping finishes, or the
+	// timer fires first
+	state := int32(mapRunning)
+	done := make(chan mapResult, 1)
+	go func() {
+		var res mapResult
+		defer func() {
+			if r := recover(); r != nil {
+				res.panicked, res.value = true, r
+			}
+			if atomic.CompareAndSwapInt32(&state, mapRunning, mapFinished) {
+				done <- res
+			} else {
+				// the caller has got TimeoutError
+				atomic.StoreInt64(&m.timeout, 0)
+			}
+		}()
+		res.err = m.Map(v, s)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		return res.result()
+	case <-timer.C:
+	}
+	atomic.StoreInt64(&m.timeout, int64(timeout))
+	if !atomic.CompareAndSwapInt32(&state, mapRunning, mapTimedOut) {
+		// completed while the timer fired
+		atomic.StoreInt64(&m.timeout, 0)
+		return (<-done).result()
+	}
+	return &TimeoutError{Timeout: timeout}
+}
+
+const (
+	mapRunning = iota
+	mapFinished
+	mapTimedOut
+)
+
+// mapResult is the result of a mapping in another goroutine
+type mapResult struct {
+	err      error
+	panicked bool
+	value    interface{}
+}
+
+func (r mapResult) result() error {
+	if r.panicked {
+		panic(r.value)
+	}
+	return r.err
+}
+
+// MapFields maps only the listed fields from the source map, and the
+// other keys are not applied. Nested fields are listed as dotted paths
+// of map names, e.g. "spec.name", and dots in the names are escaped as
+// \., e.g. labels.app\.kubernetes\.io/name
+func (m *Mapper) MapFields(v interface{}, s map[string]interface{}, fields []string) error {
+	selected := make(map[string]interface{})
+	for _, field := range fields {
+		selectField(selected, s, SplitPath(field))
+	}
+	return m.Map(v, selected)
+}
+
+func selectField(selected, s map[string]interface{}, path []string) {
+	val, exist := s[path[0]]
+	if !exist {
+		return
+	}
+	if len(path) == 1 {
+		selected[path[0]] = val
+		return
+	}
+	nested, ok := val.(map[string]interface{})
+	if !ok {
+		return
+	}
+	sub, ok := selected[path[0]].(map[string]interface{})
+	if !ok {
+		sub = make(map[string]interface{})
+		selected[path[0]] = sub
+	}
+	selectField(sub, nested, path[1:])
+}
+
+// RoundTrip maps a struct (or a pointer to a struct) to a map, and then
+// back into a new instance of the same type, which is returned for
+// comparison with the original to detect lossy tag configurations.
+// Exported fields of bool, numbers, strings, slices, maps, pointers,
+// nested structs, time.Time and structs implementing both TextMarshaler
+// and TextUnmarshaler round-trip cleanly, while unexported, ignored and
+// wildcard fields are lost, and channels and functions are not copied
+func (m *Mapper) RoundTrip(s interface{}) (interface{}, error) {
+	v := reflect.ValueOf(s)
+	u := UnwrapPtr(v)
+	if !u.IsValid() || u.Kind() != reflect.Struct {
+		return nil, errNotStruct("")
+	}
+	t := u.Type()
+	mapped := make(map[string]interface{})
+	if err := m.Map(mapped, s); err != nil {
+		return nil, err
+	}
+	out := reflect.New(t)
+	if err := m.MapValue(out, reflect.ValueOf(mapped)); err != nil {
+		return nil, err
+	}
+	if v.Kind() != reflect.Ptr {
+		return out.Elem().Interface(), nil
+	}
+	return out.Interface(), nil
+}
+
 // Map wraps Mapper.Map with a default Mapper instance
 func Map(v, s interface{}) error {
 	m := &Mapper{}
@@ -1,8 +1,20 @@
 package mapper
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"math/rand"
+	"net/url"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -388,6 +400,30 @@ type wildcardMapStruct struct {
 	Ext      map[string]interface{} `map:"*"`
 }
 
+type wildcardMultiStruct struct {
+	Name  string `map:"*"`
+	Alias string `map:"*"`
+	Int   int    `map:"*"`
+}
+
+func TestMapWildcardPrecedence(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	var s wildcardMultiStruct
+	if a.NoError(m.Map(&s, "str")) {
+		a.Equal(wildcardMultiStruct{Name: "str"}, s)
+	}
+	m.WildcardAll = true
+	s = wildcardMultiStruct{}
+	if a.NoError(m.Map(&s, "str")) {
+		a.Equal(wildcardMultiStruct{Name: "str", Alias: "str"}, s)
+	}
+	s = wildcardMultiStruct{}
+	if a.NoError(m.Map(&s, int64(10))) {
+		a.Equal(wildcardMultiStruct{Int: 10}, s)
+	}
+}
+
 func TestMapWildcardStructField(t *testing.T) {
 	a := assert.New(t)
 	m := tracedMapper(t)
@@ -480,3 +516,3004 @@ func TestStructToMap(t *testing.T) {
 		}
 	}
 }
+
+type toMapOmitInner struct {
+	Name   string   `map:"name,omitempty"`
+	Tags   []string `map:"tags,omitempty"`
+	secret string
+}
+
+type toMapOmitNested struct {
+	Inner    toMapOmitInner `map:"inner,omitempty"`
+	Kept     toMapOmitInner `map:"kept"`
+	Squashed toMapOmitInner `map:",squash"`
+}
+
+func TestStructToMapOmitEmptyNested(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	d := make(map[string]interface{})
+	if a.NoError(m.Map(d, &toMapOmitNested{})) {
+		a.Equal(map[string]interface{}{"kept": map[string]interface{}{}}, d)
+	}
+	s := &toMapOmitNested{Inner: toMapOmitInner{secret: "s"}}
+	d = make(map[string]interface{})
+	if a.NoError(m.Map(d, s)) {
+		a.NotContains(d, "inner")
+	}
+	s.Inner.Tags = []string{"t"}
+	s.Squashed.Name = "n"
+	d = make(map[string]interface{})
+	if a.NoError(m.Map(d, s)) {
+		a.Equal(map[string]interface{}{
+			"inner": map[string]interface{}{"tags": []string{"t"}},
+			"kept":  map[string]interface{}{},
+			"name":  "n",
+		}, d)
+	}
+}
+
+type nullString struct {
+	Valid  bool
+	String string
+}
+
+type toMapNullable struct {
+	Name  nullString `map:"name,omitempty"`
+	Alias nullString `map:"alias,omitempty"`
+}
+
+func TestStructToMapEmptyFunc(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	m.EmptyFunc = map[reflect.Type]func(reflect.Value) bool{
+		reflect.TypeOf(nullString{}): func(v reflect.Value) bool {
+			return !v.Interface().(nullString).Valid
+		},
+	}
+	s := &toMapNullable{Name: nullString{Valid: true}}
+	d := make(map[string]interface{})
+	if a.NoError(m.Map(d, s)) {
+		a.Contains(d, "name")
+		a.NotContains(d, "alias")
+	}
+}
+
+func TestMapSliceParallel(t *testing.T) {
+	a := assert.New(t)
+	m := &Mapper{ParallelSliceThreshold: 4}
+	src := make([]map[string]interface{}, 100)
+	for i := range src {
+		src[i] = map[string]interface{}{"Str": strconv.Itoa(i)}
+	}
+	var d []*struct1
+	if a.NoError(m.Map(&d, src)) && a.Len(d, len(src)) {
+		for i, s := range d {
+			if a.NotNil(s) {
+				a.Equal(strconv.Itoa(i), s.Str)
+			}
+		}
+	}
+	src[10]["Str"] = 1.5
+	src[20]["Str"] = 2.5
+	src[90]["Str"] = 3.5
+	err := m.Map(&d, src)
+	var mismatch *MismatchError
+	if a.True(errors.As(err, &mismatch)) {
+		a.Equal("*.10*.Str", mismatch.Loc)
+	}
+	for i := 0; i < 10; i++ {
+		a.Equal(err.Error(), m.Map(&d, src).Error())
+	}
+}
+
+func TestMapTrackPresence(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	m.TrackPresence = true
+	var s struct2
+	src := map[string]interface{}{
+		"Ref1": map[string]interface{}{"FloatPtr": nil},
+		"Ptr1": nil,
+	}
+	if a.NoError(m.Map(&s, src)) {
+		a.Nil(s.Ref1.FloatPtr)
+		a.Nil(s.Ptr1)
+		a.True(m.Presence["*.Ref1"])
+		a.True(m.Presence["*.Ref1.FloatPtr"])
+		a.True(m.Presence["*.Ptr1"])
+		a.False(m.Presence["*.Ref1.Str"])
+		a.False(m.Presence["*.Map"])
+	}
+	m = tracedMapper(t)
+	m.TrackPresence = true
+	var s1 struct1
+	if a.NoError(m.Scan([]interface{}{map[string]interface{}{"Str": "s"}}, &s1)) {
+		a.Equal("s", s1.Str)
+		a.NotEmpty(m.Presence)
+	}
+}
+
+type inlineNested struct {
+	Name string `map:"name"`
+}
+
+type inlineStruct struct {
+	Inlined  inlineNested `map:",inline"`
+	Squashed struct1      `map:",squash"`
+	Val      int          `map:"val"`
+}
+
+func TestMapInlineStructField(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	src := map[string]interface{}{"name": "n", "Str": "s", "val": 1}
+	var s inlineStruct
+	if a.NoError(m.Map(&s, src)) {
+		a.Equal("n", s.Inlined.Name)
+		a.Equal("s", s.Squashed.Str)
+		a.Equal(1, s.Val)
+	}
+	d := make(map[string]interface{})
+	if a.NoError(m.Map(d, &s)) {
+		a.Equal("n", d["name"])
+		a.Equal("s", d["Str"])
+		a.NotContains(d, "Inlined")
+	}
+}
+
+type lifecycleStruct struct {
+	First string `map:"first"`
+	Last  string `map:"last"`
+	Full  string `map:"-"`
+
+	before int
+}
+
+func (s *lifecycleStruct) BeforeMap() error {
+	s.before++
+	return nil
+}
+
+func (s *lifecycleStruct) AfterMap() error {
+	if s.First == "" {
+		return fmt.Errorf("first is required")
+	}
+	s.Full = s.First + " " + s.Last
+	return nil
+}
+
+func TestMapLifecycleHooks(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	var s lifecycleStruct
+	if a.NoError(m.Map(&s, map[string]interface{}{"first": "a", "last": "b"})) {
+		a.Equal(1, s.before)
+		a.Equal("a b", s.Full)
+	}
+	var arr []lifecycleStruct
+	err := m.Map(&arr, []interface{}{map[string]interface{}{"last": "b"}})
+	if a.Error(err) {
+		a.Contains(err.Error(), "first is required")
+		a.Contains(err.Error(), "*.0")
+	}
+}
+
+func BenchmarkMapUniformStructs(b *testing.B) {
+	src := make([]map[string]interface{}, 1000)
+	for i := range src {
+		src[i] = map[string]interface{}{
+			"strptr":   "str",
+			"Str":      "str",
+			"FloatPtr": float32(i),
+		}
+	}
+	m := &Mapper{}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		var d []struct1
+		if err := m.Map(&d, src); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestMapElemType(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	d := map[string]string{}
+	err := m.Map(&d, map[string]int{"a": 1, "b": 2})
+	if a.Error(err) {
+		a.Contains(err.Error(), "element type mismatch")
+	}
+	a.Empty(d)
+	f := map[string]float64{}
+	if a.NoError(m.Map(&f, map[string]int{"a": 1})) {
+		a.Equal(1.0, f["a"])
+	}
+}
+
+func TestMapStructNeverAlias(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	m.NeverAlias = true
+	str := "str"
+	s1 := &struct2{Ptr1: &struct1{StrPtr: &str}}
+	s1.Ref1.internal = 10
+	s2 := &struct2{}
+	if a.NoError(m.Map(s2, s1)) && a.NotNil(s2.Ptr1) && a.NotNil(s2.Ptr1.StrPtr) {
+		a.Equal(10, s2.Ref1.internal)
+		a.Equal("str", *s2.Ptr1.StrPtr)
+		a.False(s1.Ptr1 == s2.Ptr1)
+		a.False(s1.Ptr1.StrPtr == s2.Ptr1.StrPtr)
+		str = "new"
+		a.Equal("str", *s2.Ptr1.StrPtr)
+	}
+	var p *struct1
+	if a.NoError(m.Map(&p, s1.Ptr1)) && a.NotNil(p) {
+		a.False(p == s1.Ptr1)
+	}
+}
+
+type aliasStruct struct {
+	Any     interface{}
+	Items   []interface{}
+	private *string
+}
+
+func TestMapStructNeverAliasInterfaces(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	m.NeverAlias = true
+	str := "str"
+	s := aliasStruct{Any: &str, Items: []interface{}{&str, map[string]interface{}{"p": &str}}, private: &str}
+	var d aliasStruct
+	if a.NoError(m.Map(&d, s)) && a.Len(d.Items, 2) {
+		p, ok := d.Any.(*string)
+		if a.True(ok) {
+			a.False(p == &str)
+			a.Equal("str", *p)
+		}
+		a.False(d.Items[0].(*string) == &str)
+		a.False(d.Items[1].(map[string]interface{})["p"].(*string) == &str)
+		// unexported fields can't be set, and stay shared
+		a.True(d.private == &str)
+	}
+}
+
+type aliasNode struct {
+	Name string
+	Next *aliasNode
+	Any  interface{}
+}
+
+func TestMapStructNeverAliasCycles(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	m.NeverAlias = true
+	n1 := &aliasNode{Name: "n1"}
+	n2 := &aliasNode{Name: "n2", Next: n1, Any: n1}
+	n1.Next = n2
+	var d *aliasNode
+	if a.NoError(m.Map(&d, n1)) && a.NotNil(d) && a.NotNil(d.Next) {
+		a.False(d == n1)
+		a.False(d.Next == n2)
+		a.Equal("n2", d.Next.Name)
+		a.True(d.Next.Next == d)
+		a.True(d.Next.Any == d)
+	}
+	self := &aliasNode{Name: "self"}
+	self.Next = self
+	var c aliasNode
+	if a.NoError(m.Map(&c, self)) && a.NotNil(c.Next) {
+		a.False(c.Next == self)
+		a.True(c.Next.Next == c.Next)
+	}
+}
+
+func TestMapInterfaceValueStruct(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	var i interface{} = struct1{Str: "str", Skip: "skip"}
+	if a.NoError(m.Map(&i, map[string]interface{}{"strptr": "ptr"})) {
+		s, ok := i.(struct1)
+		if a.True(ok) {
+			a.Equal("str", s.Str)
+			a.Equal("skip", s.Skip)
+			if a.NotNil(s.StrPtr) {
+				a.Equal("ptr", *s.StrPtr)
+			}
+		}
+	}
+}
+
+func TestMapNoInterfaceMerge(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	src := map[string]interface{}{"v": map[string]interface{}{"b": 2}}
+	d := struct {
+		V interface{} `map:"v"`
+	}{V: map[string]interface{}{"a": 1}}
+	if a.NoError(m.Map(&d, src)) {
+		a.Equal(map[string]interface{}{"a": 1, "b": 2}, d.V)
+	}
+	m.NoInterfaceMerge = true
+	d.V = map[string]interface{}{"a": 1}
+	if a.NoError(m.Map(&d, src)) {
+		a.Equal(map[string]interface{}{"b": 2}, d.V)
+	}
+	var i interface{} = struct1{Str: "str"}
+	if a.NoError(m.Map(&i, map[string]interface{}{"strptr": "ptr"})) {
+		a.Equal(map[string]interface{}{"strptr": "ptr"}, i)
+	}
+}
+
+func TestMapOnConvert(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	var convs []string
+	m.OnConvert = func(from, to reflect.Type, loc string) {
+		convs = append(convs, from.String()+">"+to.String()+loc)
+	}
+	var s struct4
+	if a.NoError(m.Map(&s, map[string]interface{}{"str": int64(1)})) {
+		a.Equal(1, s.Int1)
+		a.Equal([]string{"int64>int*.Int1"}, convs)
+	}
+	convs = nil
+	if a.NoError(m.Map(&s, map[string]interface{}{"str": "s"})) {
+		a.Empty(convs)
+	}
+}
+
+func TestMapNilSource(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	s := struct1{Str: "str"}
+	p := &s
+	a.NoError(m.Map(&s, nil))
+	a.NoError(m.Map(&p, nil))
+	var i interface{}
+	a.NoError(m.Map(&s, i))
+	a.NoError(m.Map(&p, i))
+	var np *struct1
+	i = np
+	a.NoError(m.Map(&s, i))
+	a.NoError(m.Map(&p, i))
+	a.NoError(m.Map(&p, np))
+	a.NoError(m.MapValue(reflect.ValueOf(&s), reflect.Value{}))
+	a.Equal("str", s.Str)
+	a.True(p == &s)
+}
+
+type asTypeStruct struct {
+	Count   int     `map:"count,astype=float64"`
+	Ratio   float32 `map:"ratio,astype=string"`
+	Enabled string  `map:"enabled,astype=bool"`
+	Size    *uint   `map:"size,astype=int64"`
+}
+
+func TestStructToMapAsType(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	size := uint(5)
+	s := &asTypeStruct{Count: 3, Ratio: 0.5, Enabled: "true", Size: &size}
+	d := make(map[string]interface{})
+	if a.NoError(m.Map(d, s)) {
+		a.Equal(float64(3), d["count"])
+		a.Equal("0.5", d["ratio"])
+		a.Equal(true, d["enabled"])
+		a.Equal(int64(5), d["size"])
+	}
+	s.Enabled = "maybe"
+	err := m.Map(make(map[string]interface{}), s)
+	if a.Error(err) {
+		a.Contains(err.Error(), "astype bool")
+		a.Contains(err.Error(), ".Enabled")
+	}
+}
+
+func TestMergeStrategy(t *testing.T) {
+	a := assert.New(t)
+	base := func() map[string]interface{} {
+		return map[string]interface{}{
+			"key0": "val0",
+			"arr":  []interface{}{"a"},
+			"dict": map[string]interface{}{"key1": "val1"},
+		}
+	}
+	override := map[string]interface{}{
+		"key0": "val0.1",
+		"arr":  []interface{}{"b"},
+		"dict": map[string]interface{}{"key1": "val1.1", "key2": "val2"},
+	}
+
+	d := base()
+	if a.NoError(Merge(d, override, SourceWins)) {
+		a.Equal("val0.1", d["key0"])
+		a.Equal([]interface{}{"b"}, d["arr"])
+		a.Equal("val1.1", d["dict"].(map[string]interface{})["key1"])
+	}
+
+	d = base()
+	if a.NoError(Merge(d, override, DestWins)) {
+		a.Equal("val0", d["key0"])
+		a.Equal([]interface{}{"a"}, d["arr"])
+		a.Equal("val1", d["dict"].(map[string]interface{})["key1"])
+		a.Equal("val2", d["dict"].(map[string]interface{})["key2"])
+	}
+
+	d = base()
+	a.Error(Merge(d, override, ErrorOnConflict))
+	d = base()
+	a.NoError(Merge(d, base(), ErrorOnConflict))
+
+	s := struct1{Str: "str"}
+	if a.NoError(Merge(&s, map[string]interface{}{"Str": "new", "strptr": "p"}, DestWins)) {
+		a.Equal("str", s.Str)
+		if a.NotNil(s.StrPtr) {
+			a.Equal("p", *s.StrPtr)
+		}
+	}
+	a.Error(Merge(&s, map[string]interface{}{"Str": "new"}, ErrorOnConflict))
+	a.NoError(Merge(&s, map[string]interface{}{"Str": "str"}, ErrorOnConflict))
+}
+
+type promoted1 struct {
+	Name string `map:"name"`
+	ID   string `map:"id"`
+}
+
+type promoted2 struct {
+	Name string `map:"name"`
+	Tag  string `map:"tag"`
+}
+
+type promotedStruct struct {
+	promoted1
+	Nested promoted2 `map:",squash"`
+	Name   string    `map:"name"`
+}
+
+func TestMapPromotedFieldConflict(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	var s promotedStruct
+	src := map[string]interface{}{"name": "n", "id": "i", "tag": "t"}
+	if a.NoError(m.Map(&s, src)) {
+		a.Equal("n", s.Name)
+		a.Equal("", s.promoted1.Name)
+		a.Equal("n", s.Nested.Name)
+		a.Equal("i", s.ID)
+		a.Equal("t", s.Nested.Tag)
+	}
+	s.promoted1.Name = "p1"
+	d := make(map[string]interface{})
+	if a.NoError(m.Map(d, &s)) {
+		a.Equal("n", d["name"])
+	}
+}
+
+type embedLevel3 struct {
+	Inner string `map:"inner"`
+}
+
+type embedLevel2 struct {
+	embedLevel3
+	Middle string `map:"middle"`
+}
+
+type embedLevel1 struct {
+	embedLevel2
+	Outer string `map:"outer"`
+}
+
+type squashLevel1 struct {
+	Level embedLevel2 `map:",squash"`
+	Outer string      `map:"outer"`
+}
+
+func TestMapMultiLevelEmbedding(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	src := map[string]interface{}{"inner": "i", "middle": "m", "outer": "o"}
+	var s embedLevel1
+	if a.NoError(m.Map(&s, src)) {
+		a.Equal("o", s.Outer)
+		a.Equal("m", s.Middle)
+		a.Equal("i", s.Inner)
+	}
+	d := make(map[string]interface{})
+	if a.NoError(m.Map(d, &s)) {
+		a.Equal(src, d)
+	}
+	var sq squashLevel1
+	if a.NoError(m.Map(&sq, src)) {
+		a.Equal("o", sq.Outer)
+		a.Equal("m", sq.Level.Middle)
+		a.Equal("i", sq.Level.Inner)
+	}
+}
+
+type shadowedStructInner struct {
+	X struct {
+		A int `map:"a"`
+	} `map:"x"`
+}
+
+type shadowedStructOuter struct {
+	shadowedStructInner
+	X int `map:"x"`
+}
+
+func TestStructToMapShadowedStructField(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	s := shadowedStructOuter{X: 5}
+	s.shadowedStructInner.X.A = 7
+	d := make(map[string]interface{})
+	if a.NoError(m.Map(d, &s)) {
+		a.Equal(map[string]interface{}{"x": 5}, d)
+	}
+}
+
+func TestPromotedDepthsCache(t *testing.T) {
+	a := assert.New(t)
+	m := &Mapper{}
+	typ := reflect.TypeOf(struct {
+		A int `map:"a" json:"b"`
+	}{})
+	depths := m.promotedDepths(typ)
+	a.Equal(map[string]int{"a": 0}, depths)
+	a.Equal(reflect.ValueOf(depths).Pointer(), reflect.ValueOf(m.promotedDepths(typ)).Pointer())
+	m.FieldTags = []string{"json"}
+	a.Equal(map[string]int{"b": 0}, m.promotedDepths(typ))
+}
+
+type customContainer struct {
+	Keys []string
+}
+
+func TestMapIsContainerFunc(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	d := map[string]interface{}{"c": &customContainer{Keys: []string{"a"}}}
+	if a.NoError(m.Map(d, map[string]interface{}{"c": map[string]interface{}{"Keys": []string{"b"}}})) {
+		c, ok := d["c"].(*customContainer)
+		if a.True(ok) {
+			a.Equal([]string{"b"}, c.Keys)
+		}
+	}
+	m.IsContainerFunc = func(v reflect.Value) bool {
+		return v.Kind() == reflect.Map
+	}
+	d = map[string]interface{}{"c": &customContainer{Keys: []string{"a"}}}
+	if a.NoError(m.Map(d, map[string]interface{}{"c": map[string]interface{}{"Keys": []string{"b"}}})) {
+		_, ok := d["c"].(map[string]interface{})
+		a.True(ok, "non-container value is replaced")
+	}
+}
+
+type jsonPointerItem struct {
+	Name  string `map:"name"`
+	Value int    `map:"a/b"`
+}
+
+type jsonPointerStruct struct {
+	Items []jsonPointerItem `map:"items"`
+}
+
+func TestMapLocJSONPointer(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	m.LocFormat = JSONPointer
+	var s jsonPointerStruct
+	src := map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"name": "n", "a/b": 1},
+			map[string]interface{}{"name": 1},
+		},
+	}
+	err := m.Map(&s, src)
+	if a.Error(err) {
+		a.Contains(err.Error(), "[/items/1/name]")
+	}
+	src["items"] = []interface{}{map[string]interface{}{"a/b": "v"}}
+	err = m.Map(&s, src)
+	if a.Error(err) {
+		a.Contains(err.Error(), "[/items/0/a~1b]")
+	}
+}
+
+type scalarStruct struct {
+	Bool   bool    `map:"bool"`
+	Int    int     `map:"int"`
+	Int64  int64   `map:"int64"`
+	Uint   uint    `map:"uint"`
+	Float  float64 `map:"float"`
+	Str    string  `map:"str"`
+	Multi  string  `map:"multi"`
+	MultiI int     `map:"multi"`
+}
+
+func randomScalar(r *rand.Rand) interface{} {
+	switch r.Intn(8) {
+	case 0:
+		return r.Intn(2) == 1
+	case 1:
+		return r.Intn(100)
+	case 2:
+		return int64(r.Intn(100))
+	case 3:
+		return uint(r.Intn(100))
+	case 4:
+		return r.Float64()
+	case 5:
+		return strconv.Itoa(r.Intn(100))
+	case 6:
+		return nil
+	}
+	return []interface{}{r.Intn(100)}
+}
+
+func TestMapScalarFastPath(t *testing.T) {
+	a := assert.New(t)
+	fast := &Mapper{}
+	slow := &Mapper{Tracer: func(d, s reflect.Value, loc string) {}}
+	r := rand.New(rand.NewSource(1))
+	keys := []string{"bool", "int", "int64", "uint", "float", "str", "multi"}
+	for n := 0; n < 1000; n++ {
+		src := make(map[string]interface{})
+		for _, key := range keys {
+			if r.Intn(4) > 0 {
+				src[key] = randomScalar(r)
+			}
+		}
+		var d1, d2 scalarStruct
+		err1 := fast.Map(&d1, src)
+		err2 := slow.Map(&d2, src)
+		a.Equal(err2, err1, "%v", src)
+		a.Equal(d2, d1, "%v", src)
+	}
+}
+
+func BenchmarkMapScalarFields(b *testing.B) {
+	src := map[string]interface{}{
+		"bool":  true,
+		"int":   1,
+		"int64": int64(2),
+		"uint":  uint(3),
+		"float": 4.5,
+		"str":   "str",
+		"multi": "multi",
+	}
+	m := &Mapper{}
+	for n := 0; n < b.N; n++ {
+		var d scalarStruct
+		if err := m.Map(&d, src); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+type patternWildcardStruct struct {
+	Str  string                 `map:"str"`
+	X    map[string]interface{} `map:"x_*"`
+	Y    map[string]string      `map:"y_?"`
+	Rest map[string]interface{} `map:"*"`
+}
+
+func TestMapPatternWildcardField(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	var s patternWildcardStruct
+	src := map[string]interface{}{
+		"str":  "s",
+		"x_a":  1,
+		"x_b":  2,
+		"y_c":  "c",
+		"y_dd": "dd",
+		"z":    3,
+	}
+	if a.NoError(m.Map(&s, src)) {
+		a.Equal("s", s.Str)
+		a.Equal(map[string]interface{}{"x_a": 1, "x_b": 2}, s.X)
+		a.Equal(map[string]string{"y_c": "c"}, s.Y)
+		a.Equal(map[string]interface{}{"y_dd": "dd", "z": 3}, s.Rest)
+	}
+}
+
+func TestMapStructErrorOrder(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	src := map[string]interface{}{
+		"bool":  "b",
+		"int":   "i",
+		"int64": "i",
+		"uint":  "u",
+		"float": "f",
+	}
+	for n := 0; n < 20; n++ {
+		var s scalarStruct
+		err := m.Map(&s, src)
+		if a.Error(err) {
+			a.Contains(err.Error(), "[*.Bool]")
+		}
+	}
+}
+
+type preserveStruct struct {
+	Name   string    `map:"name"`
+	Output io.Writer `map:"output"`
+}
+
+func TestMapPreserveTypes(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	m.PreserveTypes = []reflect.Type{reflect.TypeOf((*io.Writer)(nil)).Elem()}
+	var buf bytes.Buffer
+	s := preserveStruct{Output: &buf}
+	if a.NoError(m.Map(&s, map[string]interface{}{"name": "n", "output": "stdout"})) {
+		a.Equal("n", s.Name)
+		a.True(s.Output == &buf)
+	}
+}
+
+type formStruct struct {
+	Name  string   `map:"name"`
+	Tags  []string `map:"tag"`
+	Alias *string  `map:"alias"`
+	Empty string   `map:"empty"`
+}
+
+func TestMapFormDecode(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	m.FormDecode = true
+	form := url.Values{
+		"name":  {"n1", "n2"},
+		"tag":   {"a", "b"},
+		"alias": {"x"},
+		"empty": {},
+	}
+	s := formStruct{Empty: "e"}
+	if a.NoError(m.Map(&s, form)) {
+		a.Equal("n1", s.Name)
+		a.Equal([]string{"a", "b"}, s.Tags)
+		if a.NotNil(s.Alias) {
+			a.Equal("x", *s.Alias)
+		}
+		a.Equal("e", s.Empty)
+	}
+	m.FormDecode = false
+	a.Error(m.Map(&s, form))
+}
+
+type embedIfaceStruct struct {
+	fmt.Stringer
+	X int `map:"x"`
+}
+
+func TestMapAnonInterfaceField(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	var s embedIfaceStruct
+	if a.NoError(m.Map(&s, map[string]interface{}{"x": 1, "Stringer": "s"})) {
+		a.Equal(1, s.X)
+		a.Nil(s.Stringer)
+	}
+	d := make(map[string]interface{})
+	if a.NoError(m.Map(d, &s)) {
+		a.Equal(map[string]interface{}{"x": 1}, d)
+	}
+}
+
+type complexStruct struct {
+	C64  complex64  `map:"c64"`
+	C128 complex128 `map:"c128"`
+}
+
+func TestMapComplexFormat(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	var s complexStruct
+	a.Error(m.Map(&s, map[string]interface{}{"c128": "1+2i"}))
+
+	m.ComplexFormat = ComplexString
+	if a.NoError(m.Map(&s, map[string]interface{}{"c64": "(1+2i)", "c128": "3-4i"})) {
+		a.Equal(complex64(complex(1, 2)), s.C64)
+		a.Equal(complex(3, -4), s.C128)
+	}
+	a.Error(m.Map(&s, map[string]interface{}{"c128": "abc"}))
+	cm := make(map[string]complex128)
+	if a.NoError(m.Map(cm, map[string]string{"c": "1+2i"})) {
+		a.Equal(map[string]complex128{"c": complex(1, 2)}, cm)
+	}
+	d := make(map[string]interface{})
+	if a.NoError(m.Map(d, &s)) {
+		a.Equal("(3-4i)", d["c128"])
+	}
+
+	m.ComplexFormat = ComplexMap
+	src := map[string]interface{}{
+		"c128": map[string]interface{}{"real": 5, "imag": 6.5},
+	}
+	if a.NoError(m.Map(&s, src)) {
+		a.Equal(complex(5, 6.5), s.C128)
+	}
+	d = make(map[string]interface{})
+	if a.NoError(m.Map(d, &s)) {
+		a.Equal(map[string]interface{}{"real": 5.0, "imag": 6.5}, d["c128"])
+	}
+}
+
+func TestMapFields(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	s := struct2{Ref1: struct1{Str: "s1", Skip: "skip"}}
+	src := map[string]interface{}{
+		"Ref1": map[string]interface{}{
+			"Str":      "s2",
+			"FloatPtr": 1.5,
+		},
+		"Ptr1": map[string]interface{}{"Str": "p"},
+	}
+	if a.NoError(m.MapFields(&s, src, []string{"Ref1.FloatPtr", "Map"})) {
+		a.Equal("s1", s.Ref1.Str)
+		if a.NotNil(s.Ref1.FloatPtr) {
+			a.Equal(1.5, *s.Ref1.FloatPtr)
+		}
+		a.Nil(s.Ptr1)
+		a.Nil(s.Map)
+	}
+	if a.NoError(m.MapFields(&s, src, []string{"Ptr1"})) && a.NotNil(s.Ptr1) {
+		a.Equal("p", s.Ptr1.Str)
+	}
+}
+
+type stringerKey struct {
+	X, Y int
+}
+
+func (k stringerKey) String() string {
+	return fmt.Sprintf("%d,%d", k.X, k.Y)
+}
+
+type stringerKeyStruct struct {
+	Points map[stringerKey]int `map:"points"`
+}
+
+func TestMapStringerKeys(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	points := map[stringerKey]int{{1, 2}: 3}
+	d := make(map[string]interface{})
+	if a.NoError(m.Map(d, points)) {
+		a.Equal(map[string]interface{}{"1,2": 3}, d)
+	}
+	d = make(map[string]interface{})
+	if a.NoError(m.Map(d, &stringerKeyStruct{Points: points})) {
+		a.Equal(map[string]interface{}{"1,2": 3}, d["points"])
+	}
+}
+
+func TestMapTypedErrors(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	var s struct4
+	err := m.Map(&s, map[string]interface{}{"str": 1.6})
+	var mismatch *MismatchError
+	if a.True(errors.As(err, &mismatch)) {
+		a.Equal("*.Str1", mismatch.Loc)
+		a.Equal(reflect.TypeOf(1.6), mismatch.From)
+		a.Equal(reflect.TypeOf(""), mismatch.To)
+		a.Equal("unable to assign from type float64 to string [*.Str1]", err.Error())
+	}
+	var keyErr *KeyTypeError
+	d := map[string]interface{}{}
+	a.True(errors.As(m.Map(&d, map[int]interface{}{1: 1}), &keyErr))
+	var hookErr *HookError
+	var arr []lifecycleStruct
+	err = m.Map(&arr, []interface{}{map[string]interface{}{}})
+	if a.True(errors.As(err, &hookErr)) {
+		a.Equal("*.0", hookErr.Loc)
+	}
+}
+
+func TestMapRejectNonFinite(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	var f64 float64
+	var f32 float32
+	a.NoError(m.Map(&f64, math.Inf(1)))
+	m.RejectNonFinite = true
+	a.Error(m.Map(&f64, math.NaN()))
+	a.Error(m.Map(&f32, 1e300))
+	if a.NoError(m.Map(&f32, 1.5)) {
+		a.Equal(float32(1.5), f32)
+	}
+	var s scalarStruct
+	m.Tracer = nil
+	err := m.Map(&s, map[string]interface{}{"float": math.Inf(-1)})
+	var nonFinite *NonFiniteError
+	if a.True(errors.As(err, &nonFinite)) {
+		a.Equal("*.Float", nonFinite.Loc)
+	}
+}
+
+type roundTripStruct struct {
+	Name   string            `map:"name"`
+	Tags   []string          `map:"tags"`
+	Labels map[string]string `map:"labels"`
+	Inner  *scalarStruct     `map:"inner"`
+	hidden int
+}
+
+func TestMapRoundTrip(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	src := roundTripStruct{
+		Name:   "name",
+		Tags:   []string{"a", "b"},
+		Labels: map[string]string{"k": "v"},
+		Inner:  &scalarStruct{Int: 1, Str: "str"},
+		hidden: 1,
+	}
+	out, err := m.RoundTrip(&src)
+	if a.NoError(err) {
+		src.hidden = 0
+		a.Equal(&src, out)
+	}
+	out, err = m.RoundTrip(src)
+	if a.NoError(err) {
+		a.Equal(src, out)
+	}
+	_, err = m.RoundTrip(1)
+	a.Error(err)
+	var notStruct *NotStructError
+	_, err = m.RoundTrip(nil)
+	a.True(errors.As(err, &notStruct))
+	_, err = m.RoundTrip((*roundTripStruct)(nil))
+	a.True(errors.As(err, &notStruct))
+	ts := timeStruct{Time: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), Text: textStruct{X: 1, Y: 2}}
+	out, err = m.RoundTrip(ts)
+	if a.NoError(err) {
+		a.Equal(ts, out)
+	}
+}
+
+type dashStruct struct {
+	Ignored   string `map:"-"`
+	Dash      string `map:"-,"`
+	DashEmpty string `map:"-,omitempty"`
+}
+
+func TestMapDashTagName(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	field, _ := reflect.TypeOf(dashStruct{}).FieldByName("Ignored")
+	a.True(m.ParseField(field).Ignore)
+	field, _ = reflect.TypeOf(dashStruct{}).FieldByName("Dash")
+	info := m.ParseField(field)
+	a.False(info.Ignore)
+	a.Equal("-", info.MapName)
+	var s dashStruct
+	if a.NoError(m.Map(&s, map[string]interface{}{"-": "dash"})) {
+		a.Empty(s.Ignored)
+		a.Equal("dash", s.Dash)
+		a.Equal("dash", s.DashEmpty)
+	}
+}
+
+type optionsTagInner struct {
+	Name string `json:"name"`
+}
+
+type optionsTagStruct struct {
+	Inner   optionsTagInner        `json:"inner" mapper:"squash"`
+	Count   int                    `json:"count,omitempty" mapper:"astype=string"`
+	Skipped string                 `json:"skipped" mapper:"ignore"`
+	Rest    map[string]interface{} `mapper:"wildcard"`
+}
+
+func TestMapOptionsTag(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	m.FieldTags = []string{"json"}
+	m.OptionsTag = "mapper"
+	field, _ := reflect.TypeOf(optionsTagStruct{}).FieldByName("Count")
+	info := m.ParseField(field)
+	a.Equal("count", info.MapName)
+	a.True(info.OmitEmpty)
+	a.Equal("string", info.AsType)
+	var s optionsTagStruct
+	src := map[string]interface{}{"name": "n", "count": 1, "skipped": "s", "other": "o"}
+	if a.NoError(m.Map(&s, src)) {
+		a.Equal("n", s.Inner.Name)
+		a.Equal(1, s.Count)
+		a.Empty(s.Skipped)
+		a.Equal(map[string]interface{}{"skipped": "s", "other": "o"}, s.Rest)
+	}
+	d := make(map[string]interface{})
+	if a.NoError(m.Map(d, &s)) {
+		a.Equal("n", d["name"])
+		a.Equal("1", d["count"])
+		a.NotContains(d, "skipped")
+	}
+	s.Count = 0
+	d = make(map[string]interface{})
+	if a.NoError(m.Map(d, &s)) {
+		a.NotContains(d, "count")
+	}
+}
+
+type onlyEmbedded struct {
+	Secret string `map:"secret"`
+	Public string `map:"public"`
+}
+
+type onlyStruct struct {
+	onlyEmbedded
+	Name     string       `map:"name"`
+	Password string       `map:"password"`
+	Nested   onlyEmbedded `map:"nested"`
+}
+
+func TestMapOnlyFields(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	m.OnlyFields = map[reflect.Type][]string{
+		reflect.TypeOf(onlyStruct{}):   {"name", "public", "nested"},
+		reflect.TypeOf(onlyEmbedded{}): {"secret"},
+	}
+	s := onlyStruct{
+		onlyEmbedded: onlyEmbedded{Secret: "s", Public: "p"},
+		Name:         "n",
+		Password:     "pwd",
+		Nested:       onlyEmbedded{Secret: "s1", Public: "p1"},
+	}
+	out := make(map[string]interface{})
+	if a.NoError(m.Map(out, &s)) {
+		a.Equal(map[string]interface{}{
+			"name":   "n",
+			"public": "p",
+			"nested": map[string]interface{}{"secret": "s1"},
+		}, out)
+	}
+}
+
+type textStruct struct {
+	X, Y int
+}
+
+func (s *textStruct) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d,%d", s.X, s.Y)), nil
+}
+
+func (s *textStruct) UnmarshalText(text []byte) error {
+	_, err := fmt.Sscanf(string(text), "%d,%d", &s.X, &s.Y)
+	return err
+}
+
+type timeStruct struct {
+	Time time.Time  `map:"time"`
+	Text textStruct `map:"text"`
+}
+
+func TestMapTimeToString(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	tm := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	out := make(map[string]interface{})
+	if a.NoError(m.Map(out, timeStruct{Time: tm, Text: textStruct{X: 1, Y: 2}})) {
+		a.Equal(map[string]interface{}{
+			"time": "2020-01-02T03:04:05Z",
+			"text": "1,2",
+		}, out)
+	}
+	m.TimeLayout = "2006-01-02"
+	out = make(map[string]interface{})
+	if a.NoError(m.Map(out, &timeStruct{Time: tm})) {
+		a.Equal("2020-01-02", out["time"])
+	}
+}
+
+func TestMapStringToTime(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	var s timeStruct
+	if a.NoError(m.Map(&s, map[string]interface{}{"time": "2020-01-02T03:04:05Z", "text": "1,2"})) {
+		a.True(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC).Equal(s.Time))
+		a.Equal(textStruct{X: 1, Y: 2}, s.Text)
+	}
+	m.TimeLayout = "2006-01-02"
+	if a.NoError(m.Map(&s, map[string]interface{}{"time": "2021-02-03"})) {
+		a.True(time.Date(2021, 2, 3, 0, 0, 0, 0, time.UTC).Equal(s.Time))
+	}
+	var marshalErr *MarshalError
+	if a.True(errors.As(m.Map(&s, map[string]interface{}{"time": "bad"}), &marshalErr)) {
+		a.Equal("*.Time", marshalErr.Loc)
+	}
+	a.True(errors.As(m.Map(&s, map[string]interface{}{"text": "x"}), &marshalErr))
+}
+
+func TestMapValueTransform(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	m.ValueTransform = func(dst reflect.Type, v reflect.Value, loc string) (reflect.Value, bool) {
+		v = UnwrapInterface(v)
+		if v.Kind() != reflect.String {
+			return v, false
+		}
+		if loc == "*.Multi" {
+			return reflect.Value{}, true
+		}
+		return reflect.ValueOf(strings.TrimSpace(v.String())), true
+	}
+	s := scalarStruct{Multi: "keep"}
+	err := m.Map(&s, map[string]interface{}{
+		"str":   "  text\t",
+		"int":   1,
+		"multi": " multi ",
+	})
+	if a.NoError(err) {
+		a.Equal("text", s.Str)
+		a.Equal(1, s.Int)
+		a.Equal("keep", s.Multi)
+	}
+	out := make(map[string]interface{})
+	if a.NoError(m.Map(out, map[string]interface{}{"key": " value "})) {
+		a.Equal("value", out["key"])
+	}
+}
+
+func TestMapPointerKeys(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	k1, k2 := "a", "b"
+	out := make(map[string]int)
+	if a.NoError(m.Map(out, map[*string]int{&k1: 1, &k2: 2})) {
+		a.Equal(map[string]int{"a": 1, "b": 2}, out)
+	}
+	ptrs := make(map[*string]int)
+	if a.NoError(m.Map(ptrs, map[*string]int{&k1: 1})) {
+		a.Equal(1, ptrs[&k1])
+	}
+	var s struct {
+		Val int `map:"val"`
+	}
+	err := m.Map(map[string]*struct {
+		Val int `map:"val"`
+	}{"x": &s}, map[*string]interface{}{&k1: map[string]interface{}{"val": "str"}})
+	var mismatch *MismatchError
+	if a.True(errors.As(err, &mismatch)) {
+		a.Equal(".a*.Val", mismatch.Loc)
+	}
+	a.Error(m.Map(out, map[*string]int{nil: 1}))
+}
+
+func TestMapBoolStrings(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	var b bool
+	a.Error(m.Map(&b, "yes"))
+	m.ParseBoolStrings = true
+	for str, val := range map[string]bool{"yes": true, "On": true, "1": true, "TRUE": true, "no": false, "off": false} {
+		b = !val
+		if a.NoError(m.Map(&b, str), str) {
+			a.Equal(val, b, str)
+		}
+	}
+	var s scalarStruct
+	err := m.Map(&s, map[string]interface{}{"bool": "maybe"})
+	var boolErr *BoolStringError
+	if a.True(errors.As(err, &boolErr)) {
+		a.Equal("*.Bool", boolErr.Loc)
+		a.Equal("maybe", boolErr.Value)
+	}
+	m.BoolStrings = map[string]bool{"enabled": true}
+	if a.NoError(m.Map(&s, map[string]interface{}{"bool": "Enabled"})) {
+		a.True(s.Bool)
+	}
+	a.Error(m.Map(&b, "yes"))
+	bm := make(map[string]bool)
+	if a.NoError(m.Map(bm, map[string]string{"a": "enabled"})) {
+		a.Equal(map[string]bool{"a": true}, bm)
+	}
+	m.ParseBoolStrings = false
+	var elemErr *ElemTypeError
+	a.True(errors.As(m.Map(bm, map[string]string{"a": "true"}), &elemErr))
+}
+
+func scalarMapSource(size int) map[string]interface{} {
+	src := make(map[string]interface{}, size)
+	for i := 0; i < size; i++ {
+		if i%2 == 0 {
+			src[strconv.Itoa(i)] = i
+		} else {
+			src[strconv.Itoa(i)] = float32(i)
+		}
+	}
+	return src
+}
+
+func TestMapScalarMapElemFastPath(t *testing.T) {
+	a := assert.New(t)
+	fast := &Mapper{}
+	slow := &Mapper{Tracer: func(d, s reflect.Value, loc string) {}}
+	src := scalarMapSource(100000)
+	d1, d2 := make(map[string]float64), make(map[string]float64)
+	if a.NoError(fast.Map(d1, src)) && a.NoError(slow.Map(d2, src)) {
+		a.Equal(d2, d1)
+		a.Equal(float64(99999), d1["99999"])
+	}
+	merged := map[string]interface{}{"a": map[string]interface{}{"x": 1}}
+	if a.NoError(fast.Map(merged, map[string]interface{}{"a": map[string]interface{}{"y": 2}, "b": 3})) {
+		a.Equal(map[string]interface{}{"a": map[string]interface{}{"x": 1, "y": 2}, "b": 3}, merged)
+	}
+}
+
+func BenchmarkMapScalarMap(b *testing.B) {
+	src := scalarMapSource(100000)
+	m := &Mapper{}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if err := m.Map(make(map[string]float64), src); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+type keyedItem struct {
+	Name  string `map:",key"`
+	Value int    `map:"value"`
+}
+
+func TestMapKeyField(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	src := map[string]interface{}{
+		"a": map[string]interface{}{"value": 1},
+		"b": map[string]interface{}{"value": 2},
+	}
+	var items map[string]keyedItem
+	if a.NoError(m.Map(&items, src)) {
+		a.Equal(map[string]keyedItem{
+			"a": {Name: "a", Value: 1},
+			"b": {Name: "b", Value: 2},
+		}, items)
+	}
+	var ptrs map[string]*keyedItem
+	if a.NoError(m.Map(&ptrs, src)) {
+		a.Equal("b", ptrs["b"].Name)
+	}
+}
+
+type bigStruct struct {
+	Int   *big.Int   `map:"int"`
+	Float *big.Float `map:"float"`
+	Value big.Int    `map:"value"`
+}
+
+func TestMapBigNumbers(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	var s bigStruct
+	err := m.Map(&s, map[string]interface{}{
+		"int":   "123456789012345678901234567890",
+		"float": 1.5,
+		"value": uint64(math.MaxUint64),
+	})
+	if a.NoError(err) {
+		a.Equal("123456789012345678901234567890", s.Int.String())
+		a.Equal("1.5", s.Float.String())
+		a.Equal("18446744073709551615", s.Value.String())
+	}
+	if a.NoError(m.Map(&s, map[string]interface{}{"int": 2.0, "float": "1e100"})) {
+		a.Equal(int64(2), s.Int.Int64())
+		a.Equal("1e+100", s.Float.String())
+	}
+	// the digits of long decimal strings are kept
+	const decimal = "12345678901234567890.123456789012345678901"
+	if a.NoError(m.Map(&s, map[string]interface{}{"float": decimal})) {
+		a.Equal(decimal, s.Float.Text('f', -1))
+	}
+	a.Error(m.Map(&s, map[string]interface{}{"int": 2.5}))
+	err = m.Map(&s, map[string]interface{}{"float": "abc"})
+	var parseErr *ParseNumberError
+	if a.True(errors.As(err, &parseErr)) {
+		a.Equal("*.Float*", parseErr.Loc)
+		a.Equal("abc", parseErr.Value)
+	}
+	out := make(map[string]interface{})
+	if a.NoError(m.Map(out, &bigStruct{Int: big.NewInt(1)})) {
+		a.Equal(big.NewInt(1), out["int"])
+		a.Equal("0", out["value"])
+	}
+}
+
+type mapDataStruct struct {
+	Values []string
+}
+
+func (s mapDataStruct) ToMapData() map[string]interface{} {
+	return map[string]interface{}{"values": strings.Join(s.Values, ",")}
+}
+
+func (s *mapDataStruct) FromMapData(data map[string]interface{}) error {
+	str, ok := data["values"].(string)
+	if !ok {
+		return fmt.Errorf("values is not a string")
+	}
+	s.Values = strings.Split(str, ",")
+	return nil
+}
+
+type mapDataOuter struct {
+	Data mapDataStruct `map:"data"`
+}
+
+func TestMapDataer(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	out := make(map[string]interface{})
+	if a.NoError(m.Map(out, mapDataStruct{Values: []string{"a", "b"}})) {
+		a.Equal(map[string]interface{}{"values": "a,b"}, out)
+	}
+	out = make(map[string]interface{})
+	if a.NoError(m.Map(out, &mapDataOuter{Data: mapDataStruct{Values: []string{"a"}}})) {
+		a.Equal(map[string]interface{}{"data": map[string]interface{}{"values": "a"}}, out)
+	}
+	var s mapDataOuter
+	if a.NoError(m.Map(&s, out)) {
+		a.Equal([]string{"a"}, s.Data.Values)
+	}
+	err := m.Map(&s, map[string]interface{}{"data": map[string]interface{}{"values": 1}})
+	var hookErr *HookError
+	if a.True(errors.As(err, &hookErr)) {
+		a.Equal("*.Data", hookErr.Loc)
+	}
+}
+
+func TestMapMergeStructPtrElem(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	str := "ptr"
+	elem := &struct1{Str: "s1", StrPtr: &str}
+	d := map[string]*struct1{"k1": elem}
+	if a.NoError(m.Map(d, map[string]interface{}{"k1": map[string]interface{}{"Str": "s2"}})) {
+		a.True(elem == d["k1"])
+		a.Equal("s2", elem.Str)
+		if a.NotNil(elem.StrPtr) {
+			a.Equal("ptr", *elem.StrPtr)
+		}
+	}
+}
+
+func TestMapTimeout(t *testing.T) {
+	a := assert.New(t)
+	m := &Mapper{}
+	src := make([]interface{}, 100)
+	for i := range src {
+		src[i] = i
+	}
+	// the mapping goroutine resets the flag when it stops after a timeout
+	waitMapping := func() {
+		for atomic.LoadInt64(&m.timeout) != 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	var d []int
+	if a.NoError(m.MapTimeout(time.Second, &d, src)) {
+		a.Len(d, 100)
+	}
+	m.ValueTransform = func(dst reflect.Type, v reflect.Value, loc string) (reflect.Value, bool) {
+		time.Sleep(time.Millisecond)
+		return v, false
+	}
+	err := m.MapTimeout(10*time.Millisecond, &d, src)
+	var timeoutErr *TimeoutError
+	if a.True(errors.As(err, &timeoutErr)) {
+		a.Equal(10*time.Millisecond, timeoutErr.Timeout)
+	}
+	waitMapping()
+
+	// a blocked callback doesn't block the caller
+	release := make(chan struct{})
+	m.ValueTransform = func(dst reflect.Type, v reflect.Value, loc string) (reflect.Value, bool) {
+		<-release
+		return v, false
+	}
+	start := time.Now()
+	err = m.MapTimeout(10*time.Millisecond, &d, src)
+	a.True(errors.As(err, &timeoutErr))
+	a.True(time.Since(start) < time.Second)
+	close(release)
+	waitMapping()
+
+	m.ValueTransform = func(dst reflect.Type, v reflect.Value, loc string) (reflect.Value, bool) {
+		panic("transform")
+	}
+	a.PanicsWithValue("transform", func() {
+		m.MapTimeout(time.Second, &d, src)
+	})
+	m.ValueTransform = nil
+	a.NoError(m.Map(&d, src))
+}
+
+func TestMapOmitEmptyPtr(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	type ptrStruct struct {
+		Int *int `map:"int,omitempty"`
+	}
+	out := make(map[string]interface{})
+	if a.NoError(m.Map(out, &ptrStruct{})) {
+		a.Empty(out)
+	}
+	zero := 0
+	if a.NoError(m.Map(out, &ptrStruct{Int: &zero})) {
+		a.Equal(map[string]interface{}{"int": &zero}, out)
+	}
+	a.True(IsEmpty(reflect.ValueOf((*int)(nil))))
+	a.False(IsEmpty(reflect.ValueOf(&zero)))
+}
+
+type statusKey string
+
+func TestMapKeyValidators(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	src := map[string]interface{}{"active": 1, "unknown": 2}
+	var d map[statusKey]int
+	if a.NoError(m.Map(&d, src)) {
+		a.Equal(2, d["unknown"])
+	}
+	m.KeyValidators = map[reflect.Type]func(reflect.Value) bool{
+		reflect.TypeOf(statusKey("")): func(v reflect.Value) bool {
+			return v.String() == "active" || v.String() == "inactive"
+		},
+	}
+	d = nil
+	err := m.Map(&d, src)
+	var keyErr *InvalidKeyError
+	if a.True(errors.As(err, &keyErr)) {
+		a.Equal("*.unknown", keyErr.Loc)
+		a.Equal("unknown", keyErr.Key)
+	}
+	d = nil
+	if a.NoError(m.Map(&d, map[string]interface{}{"active": 1})) {
+		a.Equal(map[statusKey]int{"active": 1}, d)
+	}
+}
+
+func TestMapScan(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	var str string
+	var num int
+	if a.NoError(m.Scan(struct1{Str: "str"}, nil, &str, nil, nil)) {
+		a.Equal("str", str)
+	}
+	if a.NoError(m.Scan([]interface{}{"a", 1}, &str, &num)) {
+		a.Equal("a", str)
+		a.Equal(1, num)
+	}
+	var countErr *ScanCountError
+	if a.True(errors.As(m.Scan([]int{1, 2}, &num), &countErr)) {
+		a.Equal(2, countErr.Values)
+		a.Equal(1, countErr.Dests)
+	}
+	var mismatch *MismatchError
+	if a.True(errors.As(m.Scan([]interface{}{"a"}, &num), &mismatch)) {
+		a.Equal(".0*", mismatch.Loc)
+	}
+	a.Error(m.Scan(1, &num))
+	var invalid *InvalidValueError
+	a.True(errors.As(m.Scan(nil, &num), &invalid))
+	a.True(errors.As(m.Scan((*struct1)(nil), &num), &invalid))
+	a.NotPanics(func() {
+		_ = (&MismatchError{}).Error()
+	})
+}
+
+type shape interface {
+	Area() float64
+}
+
+type circleShape struct {
+	Radius float64 `map:"radius"`
+}
+
+func (c *circleShape) Area() float64 {
+	return 3 * c.Radius * c.Radius
+}
+
+type squareShape struct {
+	Side float64 `map:"side"`
+}
+
+func (s squareShape) Area() float64 {
+	return s.Side * s.Side
+}
+
+func TestMapInterfaceResolvers(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	src := []interface{}{
+		map[string]interface{}{"kind": "circle", "radius": 1.0},
+		map[string]interface{}{"kind": "square", "side": 2.0},
+	}
+	var shapes []shape
+	a.Error(m.Map(&shapes, src))
+	m.InterfaceResolvers = map[reflect.Type]func(reflect.Value) reflect.Type{
+		reflect.TypeOf((*shape)(nil)).Elem(): func(s reflect.Value) reflect.Type {
+			if v, ok := UnwrapAny(s).Interface().(map[string]interface{}); ok {
+				switch v["kind"] {
+				case "circle":
+					return reflect.TypeOf(&circleShape{})
+				case "square":
+					return reflect.TypeOf(squareShape{})
+				}
+			}
+			return nil
+		},
+	}
+	if a.NoError(m.Map(&shapes, src)) && a.Len(shapes, 2) {
+		a.Equal(&circleShape{Radius: 1}, shapes[0])
+		a.Equal(squareShape{Side: 2}, shapes[1])
+	}
+}
+
+func TestMapResultTracer(t *testing.T) {
+	a := assert.New(t)
+	m := &Mapper{}
+	results := make(map[string]string)
+	m.ResultTracer = func(loc string, assigned bool, err error) {
+		switch {
+		case err != nil:
+			results[loc] = "error"
+		case assigned:
+			results[loc] = "assigned"
+		default:
+			results[loc] = "visited"
+		}
+	}
+	var s scalarStruct
+	a.NoError(m.Map(&s, map[string]interface{}{"int": 1, "str": nil, "multi": "m"}))
+	a.Equal(map[string]string{
+		"*.Int":    "assigned",
+		"*.Str":    "visited",
+		"*.Multi":  "assigned",
+		"*.MultiI": "error",
+	}, results)
+}
+
+type bytesStruct struct {
+	Key []byte `map:"key"`
+}
+
+func TestMapByteSliceFormat(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	var s bytesStruct
+	a.Error(m.Map(&s, map[string]interface{}{"key": "0a0b"}))
+	out := make(map[string]interface{})
+	if a.NoError(m.Map(out, &bytesStruct{Key: []byte{10, 11}})) {
+		a.Equal([]byte{10, 11}, out["key"])
+	}
+	m.ByteSliceFormat = BytesHex
+	if a.NoError(m.Map(&s, map[string]interface{}{"key": "0a0b"})) {
+		a.Equal([]byte{10, 11}, s.Key)
+	}
+	if a.NoError(m.Map(out, &s)) {
+		a.Equal("0a0b", out["key"])
+	}
+	err := m.Map(&s, map[string]interface{}{"key": "xyz"})
+	var decodeErr *DecodeBytesError
+	if a.True(errors.As(err, &decodeErr)) {
+		a.Equal("*.Key", decodeErr.Loc)
+	}
+	m.ByteSliceFormat = BytesBase64
+	if a.NoError(m.Map(&s, map[string]interface{}{"key": "AQI="})) {
+		a.Equal([]byte{1, 2}, s.Key)
+	}
+	if a.NoError(m.Map(out, &s)) {
+		a.Equal("AQI=", out["key"])
+	}
+}
+
+func TestMapLeftoverKeysOrder(t *testing.T) {
+	a := assert.New(t)
+	m := &Mapper{}
+	src := make(map[string]interface{})
+	for i := 0; i < 20; i++ {
+		src["x_"+strconv.Itoa(i)] = i
+		src["y_"+strconv.Itoa(i%10)] = strconv.Itoa(i)
+		src["z"+strconv.Itoa(i)] = i
+	}
+	var first patternWildcardStruct
+	if a.NoError(m.Map(&first, src)) {
+		a.Len(first.X, 20)
+		a.Len(first.Y, 10)
+		a.Len(first.Rest, 20)
+	}
+	for n := 0; n < 10; n++ {
+		var s patternWildcardStruct
+		if a.NoError(m.Map(&s, src)) {
+			a.Equal(first, s)
+		}
+	}
+}
+
+func TestMapNumericMapToSlice(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	src := map[string]interface{}{"0": "a", "2": "c"}
+	var d []string
+	a.Error(m.Map(&d, src))
+	m.NumericMapToSlice = true
+	if a.NoError(m.Map(&d, src)) {
+		a.Equal([]string{"a", "", "c"}, d)
+	}
+	var s struct {
+		Items []int `map:"items"`
+	}
+	if a.NoError(m.Map(&s, map[string]interface{}{"items": map[interface{}]interface{}{1: 1, "0": 0}})) {
+		a.Equal([]int{0, 1}, s.Items)
+	}
+	err := m.Map(&d, map[string]interface{}{"0": "a", "x": "b"})
+	var keyErr *InvalidKeyError
+	if a.True(errors.As(err, &keyErr)) {
+		a.Equal("x", keyErr.Key)
+	}
+	for _, key := range []string{"99999999999999", "65536"} {
+		err = m.Map(&d, map[string]interface{}{key: "a"})
+		if a.True(errors.As(err, &keyErr)) {
+			a.Equal(key, keyErr.Key)
+		}
+	}
+	a.Error(m.Map(&d, map[interface{}]interface{}{uint64(1) << 63: "a"}))
+	m.NumericIndexLimit = 2
+	if a.NoError(m.Map(&d, src)) {
+		a.Equal([]string{"a", "", "c"}, d)
+	}
+	a.Error(m.Map(&d, map[string]interface{}{"3": "d"}))
+}
+
+var errHookSentinel = errors.New("hook failed")
+
+type sentinelHookStruct struct {
+	Str string `map:"str"`
+}
+
+func (s *sentinelHookStruct) AfterMap() error {
+	if s.Str == "" {
+		return errHookSentinel
+	}
+	return nil
+}
+
+func TestMapErrorChain(t *testing.T) {
+	a := assert.New(t)
+	m := &Mapper{ParallelSliceThreshold: 4}
+	src := make([]interface{}, 10)
+	for i := range src {
+		src[i] = map[string]interface{}{"str": strconv.Itoa(i)}
+	}
+	src[5] = map[string]interface{}{"str": 1.5}
+	src[7] = map[string]interface{}{}
+	var d []sentinelHookStruct
+	err := m.Map(&d, src)
+	a.True(errors.Is(err, errHookSentinel))
+	var mismatch *MismatchError
+	if a.True(errors.As(err, &mismatch)) {
+		a.Equal("*.5.Str", mismatch.Loc)
+	}
+}
+
+func TestUnwrapErrors(t *testing.T) {
+	a := assert.New(t)
+	for _, err := range []error{
+		&HookError{Err: errHookSentinel},
+		&MarshalError{Err: errHookSentinel},
+		&DecodeBytesError{Err: errHookSentinel},
+		&ConverterError{Err: errHookSentinel},
+		&PanicError{Value: errHookSentinel},
+		&EnvError{Err: errHookSentinel},
+		&DecompressError{Err: errHookSentinel},
+		&TemplateError{Err: errHookSentinel},
+	} {
+		a.True(errors.Is(err, errHookSentinel), "%T", err)
+	}
+	a.Nil(errors.Unwrap(&PanicError{Value: "value"}))
+}
+
+type setterStruct struct {
+	name  string
+	count int
+}
+
+func (s *setterStruct) SetName(name string) {
+	s.name = name
+}
+
+func (s *setterStruct) SetCount(count int) error {
+	if count < 0 {
+		return fmt.Errorf("negative count")
+	}
+	s.count = count
+	return nil
+}
+
+func TestMapSetters(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	src := map[string]interface{}{"name": "n", "count": 1}
+	var s setterStruct
+	if a.NoError(m.Map(&s, src)) {
+		a.Equal(setterStruct{}, s)
+	}
+	m.UseSetters = true
+	if a.NoError(m.Map(&s, src)) {
+		a.Equal(setterStruct{name: "n", count: 1}, s)
+	}
+	err := m.Map(&s, map[string]interface{}{"count": -1})
+	var hookErr *HookError
+	if a.True(errors.As(err, &hookErr)) {
+		a.Equal("*.SetCount", hookErr.Loc)
+	}
+	var mismatch *MismatchError
+	if a.True(errors.As(m.Map(&s, map[string]interface{}{"name": 1}), &mismatch)) {
+		a.Equal("*.SetName", mismatch.Loc)
+	}
+}
+
+func TestMapRecoverPanics(t *testing.T) {
+	a := assert.New(t)
+	m := &Mapper{}
+	m.ValueTransform = func(dst reflect.Type, v reflect.Value, loc string) (reflect.Value, bool) {
+		panic("transform")
+	}
+	var s scalarStruct
+	src := map[string]interface{}{"str": "s"}
+	a.Panics(func() { m.Map(&s, src) })
+	m.RecoverPanics = true
+	err := m.Map(&s, src)
+	var panicErr *PanicError
+	if a.True(errors.As(err, &panicErr)) {
+		a.Equal("*.Str", panicErr.Loc)
+		a.Equal("transform", panicErr.Value)
+	}
+}
+
+func TestMapWithDefaults(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	m.NeverAlias = true
+	proto := roundTripStruct{
+		Name:   "proto",
+		Tags:   []string{"a"},
+		Labels: map[string]string{"k": "v"},
+	}
+	var s roundTripStruct
+	err := m.MapWithDefaults(&s, map[string]interface{}{
+		"tags":   []string{"b"},
+		"labels": map[string]interface{}{"k1": "v1"},
+	}, &proto)
+	if a.NoError(err) {
+		a.Equal("proto", s.Name)
+		a.Equal([]string{"b"}, s.Tags)
+		a.Equal(map[string]string{"k": "v", "k1": "v1"}, s.Labels)
+		a.Equal(map[string]string{"k": "v"}, proto.Labels)
+	}
+}
+
+type nullStruct struct {
+	Ptr   *int           `map:"ptr"`
+	Iface interface{}    `map:"iface"`
+	Slice []int          `map:"slice"`
+	Map   map[string]int `map:"map"`
+	Omit  *int           `map:"omit,omitempty"`
+}
+
+func TestMapEmitNulls(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	out := make(map[string]interface{})
+	if a.NoError(m.Map(out, &nullStruct{})) {
+		a.Equal((*int)(nil), out["ptr"])
+		a.Equal([]int(nil), out["slice"])
+	}
+	m.EmitNulls = true
+	out = make(map[string]interface{})
+	if a.NoError(m.Map(out, &nullStruct{})) {
+		a.Len(out, 4)
+		for _, key := range []string{"ptr", "iface", "slice", "map"} {
+			val, exist := out[key]
+			a.True(exist, key)
+			a.True(val == nil, key)
+		}
+	}
+	out = make(map[string]interface{})
+	if a.NoError(m.Map(out, &nullStruct{Slice: []int{}})) {
+		a.Equal([]int{}, out["slice"])
+	}
+}
+
+func TestMapNilPtrSource(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	d := struct1{Str: "keep"}
+	if a.NoError(m.Map(&d, (*struct1)(nil))) {
+		a.Equal("keep", d.Str)
+	}
+	var s struct2
+	s.Ref1.Str = "keep"
+	if a.NoError(m.Map(&s, map[string]interface{}{"Ref1": (*struct1)(nil)})) {
+		a.Equal("keep", s.Ref1.Str)
+	}
+	if a.NoError(m.MapValue(reflect.ValueOf(&d).Elem(), reflect.ValueOf((*map[string]interface{})(nil)))) {
+		a.Equal("keep", d.Str)
+	}
+}
+
+type centsStruct struct {
+	Amount int64 `map:"amount,conv=cents"`
+	Count  int   `map:"count,conv=missing"`
+}
+
+func TestMapFieldConverters(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	m.FieldConverters = map[string]FieldConverter{
+		"cents": func(v reflect.Value, loc string) (reflect.Value, error) {
+			v = UnwrapInterface(v)
+			switch v.Kind() {
+			case reflect.Float64:
+				return reflect.ValueOf(int64(math.Round(v.Float() * 100))), nil
+			case reflect.Int64:
+				return reflect.ValueOf(float64(v.Int()) / 100), nil
+			}
+			return reflect.Value{}, fmt.Errorf("unexpected %s", v.Kind())
+		},
+	}
+	var s centsStruct
+	if a.NoError(m.Map(&s, map[string]interface{}{"amount": 12.34})) {
+		a.Equal(int64(1234), s.Amount)
+	}
+	out := make(map[string]interface{})
+	err := m.Map(out, &s)
+	var convErr *ConverterError
+	if a.True(errors.As(err, &convErr)) {
+		a.Equal("missing", convErr.Name)
+		a.Equal(".Count", convErr.Loc)
+	}
+	m.FieldConverters["missing"] = func(v reflect.Value, loc string) (reflect.Value, error) {
+		return v, nil
+	}
+	if a.NoError(m.Map(out, &s)) {
+		a.Equal(12.34, out["amount"])
+		a.Equal(0, out["count"])
+	}
+	err = m.Map(&s, map[string]interface{}{"amount": "x"})
+	if a.True(errors.As(err, &convErr)) {
+		a.Equal("cents", convErr.Name)
+		a.Equal("*.Amount", convErr.Loc)
+	}
+}
+
+type datedStruct struct {
+	CreatedAt interface{} `map:"created_at,conv=bypath"`
+	Count     interface{} `map:"count,conv=bypath"`
+}
+
+func TestMapFieldConverterLoc(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	m.FieldConverters = map[string]FieldConverter{
+		"bypath": func(v reflect.Value, loc string) (reflect.Value, error) {
+			str := UnwrapInterface(v).String()
+			if strings.HasSuffix(loc, ".CreatedAt") {
+				tm, err := time.Parse("2006-01-02", str)
+				return reflect.ValueOf(tm), err
+			}
+			n, err := strconv.Atoi(str)
+			return reflect.ValueOf(n), err
+		},
+	}
+	var s datedStruct
+	if a.NoError(m.Map(&s, map[string]interface{}{"created_at": "2020-01-02", "count": "12"})) {
+		a.Equal(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC), s.CreatedAt)
+		a.Equal(12, s.Count)
+	}
+}
+
+// DynamicEmbedded is exported, as reflect.StructOf only embeds
+// exported types
+type DynamicEmbedded struct {
+	Inner string `map:"inner"`
+}
+
+func TestMapStructOf(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	typ := reflect.StructOf([]reflect.StructField{
+		{Name: "Name", Type: reflect.TypeOf(""), Tag: `map:"name"`},
+		{Name: "Squashed", Type: reflect.TypeOf(scalarStruct{}), Tag: `map:",squash"`},
+		{Name: "DynamicEmbedded", Type: reflect.TypeOf(DynamicEmbedded{}), Anonymous: true},
+		{Name: "Rest", Type: reflect.TypeOf(map[string]interface{}{}), Tag: `map:"*"`},
+	})
+	src := map[string]interface{}{
+		"name":  "n",
+		"int":   1,
+		"inner": "i",
+		"other": "o",
+	}
+	d := reflect.New(typ)
+	if a.NoError(m.MapValue(d, reflect.ValueOf(src))) {
+		v := d.Elem()
+		a.Equal("n", v.Field(0).String())
+		a.Equal(1, v.Field(1).Interface().(scalarStruct).Int)
+		a.Equal("i", v.Field(2).Interface().(DynamicEmbedded).Inner)
+		a.Equal(map[string]interface{}{"other": "o"}, v.Field(3).Interface())
+	}
+	out := make(map[string]interface{})
+	v := reflect.New(typ).Elem()
+	v.Field(0).SetString("n")
+	v.Field(2).Field(0).SetString("i")
+	if a.NoError(m.MapValue(reflect.ValueOf(out), v)) {
+		a.Equal("n", out["name"])
+		a.Equal("i", out["inner"])
+		a.Equal(0, out["int"])
+	}
+}
+
+type caseStruct struct {
+	Name  string `map:"name,ci"`
+	Value string `map:"value"`
+	ID    string `map:"id,cs"`
+}
+
+func TestMapCaseInsensitive(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	src := map[string]interface{}{"NAME": "n", "VALUE": "v"}
+	var s caseStruct
+	if a.NoError(m.Map(&s, src)) {
+		a.Equal(caseStruct{Name: "n"}, s)
+	}
+	if a.NoError(m.Map(&s, map[string]interface{}{"Name": "x", "name": "y"})) {
+		a.Equal("y", s.Name)
+	}
+	m.CaseInsensitive = true
+	s = caseStruct{}
+	if a.NoError(m.Map(&s, src)) {
+		a.Equal(caseStruct{Name: "n", Value: "v"}, s)
+	}
+	s = caseStruct{}
+	if a.NoError(m.Map(&s, map[string]interface{}{"ID": "x", "Value": "v"})) {
+		a.Equal(caseStruct{Value: "v"}, s)
+	}
+	if a.NoError(m.Map(&s, map[string]interface{}{"id": "x"})) {
+		a.Equal("x", s.ID)
+	}
+}
+
+func TestMapNameOf(t *testing.T) {
+	a := assert.New(t)
+	m := &Mapper{}
+	name, ok := m.MapNameOf(reflect.TypeOf(&struct1{}), "StrPtr")
+	a.True(ok)
+	a.Equal("strptr", name)
+	name, ok = m.MapNameOf(reflect.TypeOf(struct1{}), "Str")
+	a.True(ok)
+	a.Equal("Str", name)
+	for _, field := range []string{"Skip", "internal", "Missing"} {
+		_, ok = m.MapNameOf(reflect.TypeOf(struct1{}), field)
+		a.False(ok, field)
+	}
+	name, ok = m.MapNameOf(reflect.TypeOf(onlyStruct{}), "Secret")
+	a.True(ok)
+	a.Equal("secret", name)
+	_, ok = m.MapNameOf(reflect.TypeOf(patternWildcardStruct{}), "Rest")
+	a.False(ok)
+	m.FieldTags = []string{"json"}
+	name, _ = m.MapNameOf(reflect.TypeOf(struct1{}), "StrPtr")
+	a.Equal("StrPtr", name)
+}
+
+func TestMapFuncAdapter(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	var fn func() int
+	src := func() int64 { return 10 }
+	var sigErr *FuncSignatureError
+	if a.True(errors.As(m.Map(&fn, src), &sigErr)) {
+		a.Equal(reflect.TypeOf(src), sigErr.From)
+		a.Equal(reflect.TypeOf(fn), sigErr.To)
+	}
+	m.FuncAdapter = func(dst, src reflect.Type) TypeConverter {
+		if dst.NumIn() != 0 || src.NumIn() != 0 || dst.NumOut() != 1 || src.NumOut() != 1 ||
+			!src.Out(0).ConvertibleTo(dst.Out(0)) {
+			return nil
+		}
+		return func(v reflect.Value) reflect.Value {
+			return reflect.MakeFunc(dst, func([]reflect.Value) []reflect.Value {
+				return []reflect.Value{v.Call(nil)[0].Convert(dst.Out(0))}
+			})
+		}
+	}
+	if a.NoError(m.Map(&fn, src)) {
+		a.Equal(10, fn())
+	}
+	a.Error(m.Map(&fn, func(int) int { return 0 }))
+}
+
+type envServer struct {
+	Host string `map:"host"`
+	Port int    `map:"port"`
+}
+
+type envConfig struct {
+	DB      envServer   `map:"db"`
+	DBName  string      `map:"db_name"`
+	Debug   bool        `map:"debug"`
+	Hosts   []string    `map:"hosts"`
+	Servers []envServer `map:"servers"`
+}
+
+func TestMapEnv(t *testing.T) {
+	a := assert.New(t)
+	e := &EnvMapper{Prefix: "APP_"}
+	environ := []string{
+		"APP_DB_HOST=localhost",
+		"APP_DB_PORT=5432",
+		"APP_DB_NAME=test",
+		"app_debug=true",
+		"APP_HOSTS_0=a",
+		"APP_HOSTS_1=b",
+		"APP_HOSTS_3=d",
+		"APP_SERVERS_0_HOST=s0",
+		"APP_SERVERS_1_PORT=81",
+		"OTHER_DB_HOST=other",
+	}
+	var c envConfig
+	if a.NoError(e.MapEnviron(&c, environ)) {
+		a.Equal(envConfig{
+			DB:      envServer{Host: "localhost", Port: 5432},
+			DBName:  "test",
+			Debug:   true,
+			Hosts:   []string{"a", "b"},
+			Servers: []envServer{{Host: "s0"}, {Port: 81}},
+		}, c)
+	}
+	var envErr *EnvError
+	if a.True(errors.As(e.MapEnviron(&c, []string{"APP_DB_PORT=x"}), &envErr)) {
+		a.Equal("APP_DB_PORT", envErr.Name)
+	}
+	e.Prefix = "APP"
+	c = envConfig{}
+	if a.NoError(e.MapEnviron(&c, []string{"APP_DEBUG=true", "APPLE_DEBUG=x"})) {
+		a.True(c.Debug)
+	}
+}
+
+type envNode struct {
+	Name string   `map:"name"`
+	Next *envNode `map:"next"`
+}
+
+func TestMapEnvRecursiveType(t *testing.T) {
+	a := assert.New(t)
+	e := &EnvMapper{Prefix: "APP"}
+	var n envNode
+	if a.NoError(e.MapEnviron(&n, []string{"APP_NAME=a"})) {
+		a.Equal(envNode{Name: "a"}, n)
+	}
+	if a.NoError(e.MapEnviron(&n, []string{"APP_NAME=a", "APP_NEXT_NEXT_NAME=c"})) && a.NotNil(n.Next) && a.NotNil(n.Next.Next) {
+		a.Equal("c", n.Next.Next.Name)
+		a.Nil(n.Next.Next.Next)
+	}
+}
+
+func TestMapChannelIO(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	m.ChannelIO = true
+	ch := make(chan int, 3)
+	if a.NoError(m.Map(&ch, []interface{}{1, 2})) {
+		a.Equal(1, <-ch)
+		a.Equal(2, <-ch)
+	}
+	var created chan string
+	if a.NoError(m.Map(&created, []string{"a", "b"})) {
+		a.Equal(2, cap(created))
+		a.Equal("a", <-created)
+	}
+	var fullErr *ChannelFullError
+	if a.True(errors.As(m.Map(&ch, []int{1, 2, 3, 4}), &fullErr)) {
+		a.Equal("*.3", fullErr.Loc)
+	}
+	for len(ch) > 0 {
+		<-ch
+	}
+
+	ch <- 1
+	ch <- 2
+	ch <- 3
+	m.ChannelBound = 2
+	var s []int64
+	if a.NoError(m.Map(&s, ch)) {
+		a.Equal([]int64{1, 2}, s)
+		a.Len(ch, 1)
+	}
+	m.ChannelBound = 0
+	close(ch)
+	if a.NoError(m.Map(&s, ch)) {
+		a.Equal([]int64{3}, s)
+	}
+
+	m.ChannelIO = false
+	alias := make(chan int, 1)
+	var dst chan int
+	if a.NoError(m.Map(&dst, alias)) {
+		a.Equal(alias, dst)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := assert.New(t)
+	old := &envConfig{
+		DB:      envServer{Host: "localhost", Port: 5432},
+		Hosts:   []string{"a", "b"},
+		Servers: []envServer{{Host: "s0"}},
+	}
+	updated := &envConfig{
+		DB:      envServer{Host: "db", Port: 5432},
+		Hosts:   []string{"a"},
+		Servers: []envServer{{Host: "s0"}, {Host: "s1"}},
+	}
+	changes, err := Diff(old, updated)
+	if a.NoError(err) {
+		a.Equal([]Change{
+			{Loc: ".db.host", Kind: ChangeModified, Old: "localhost", New: "db"},
+			{Loc: ".hosts.1", Kind: ChangeRemoved, Old: "b"},
+			{Loc: ".servers.1", Kind: ChangeAdded, New: envServer{Host: "s1"}},
+		}, changes)
+	}
+
+	changes, err = Diff(map[string]interface{}{
+		"a": 1,
+		"b": map[string]interface{}{"c": true},
+	}, map[string]interface{}{
+		"a": 1.0,
+		"b": map[string]interface{}{"d": "x"},
+	})
+	if a.NoError(err) {
+		a.Equal([]Change{
+			{Loc: ".b.c", Kind: ChangeRemoved, Old: true},
+			{Loc: ".b.d", Kind: ChangeAdded, New: "x"},
+		}, changes)
+	}
+
+	changes, err = Diff(old, old)
+	if a.NoError(err) {
+		a.Empty(changes)
+	}
+}
+
+type indexedStruct struct {
+	Name  string `map:"0"`
+	Count int    `map:"1"`
+	Extra bool   `map:"2"`
+	Other string `map:"other"`
+}
+
+func TestMapSliceToIndexedFields(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	var s indexedStruct
+	if a.NoError(m.Map(&s, []interface{}{"a", 2})) {
+		a.Equal(indexedStruct{Name: "a", Count: 2}, s)
+	}
+	err := m.Map(&s, []interface{}{1, 2})
+	if a.Error(err) {
+		a.Contains(err.Error(), "*.Name")
+	}
+	var notIndexed struct1
+	a.Error(m.Map(&notIndexed, []interface{}{"a"}))
+}
+
+func TestMapNilSliceElemToPtr(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	var s []*struct1
+	src := []interface{}{nil, map[string]interface{}{"Str": "s1"}, (*struct1)(nil)}
+	if a.NoError(m.Map(&s, src)) && a.Len(s, 3) {
+		a.Nil(s[0])
+		if a.NotNil(s[1]) {
+			a.Equal("s1", s[1].Str)
+		}
+		a.Nil(s[2])
+	}
+}
+
+type omitAllStruct struct {
+	Name    string            `map:"name"`
+	Count   int               `map:"count,keepempty"`
+	Tags    []string          `map:"tags"`
+	Labels  map[string]string `map:"labels"`
+	Ptr     *string           `map:"ptr"`
+	Enabled bool
+	Nested  struct1
+}
+
+func TestMapOmitEmptyAll(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	m.OmitEmptyAll = true
+	out := make(map[string]interface{})
+	if a.NoError(m.Map(out, &omitAllStruct{Tags: []string{"a"}})) {
+		a.Equal(map[string]interface{}{
+			"count": 0,
+			"tags":  []string{"a"},
+		}, out)
+	}
+	m.OmitEmptyAll = false
+	out = make(map[string]interface{})
+	if a.NoError(m.Map(out, &omitAllStruct{})) {
+		a.Len(out, 7)
+	}
+}
+
+var fastPathTypes = []reflect.Type{
+	reflect.TypeOf(false),
+	reflect.TypeOf(""),
+	reflect.TypeOf(int(0)),
+	reflect.TypeOf(int8(0)),
+	reflect.TypeOf(int16(0)),
+	reflect.TypeOf(int32(0)),
+	reflect.TypeOf(int64(0)),
+	reflect.TypeOf(uint(0)),
+	reflect.TypeOf(uint8(0)),
+	reflect.TypeOf(uint16(0)),
+	reflect.TypeOf(uint32(0)),
+	reflect.TypeOf(uint64(0)),
+	reflect.TypeOf(uintptr(0)),
+	reflect.TypeOf(float32(0)),
+	reflect.TypeOf(float64(0)),
+	reflect.TypeOf(statusKey("")),
+	reflect.TypeOf(namedFloat(0)),
+}
+
+type namedFloat float32
+
+func fastPathSources(b bool, str string, n int64, f float64) []reflect.Value {
+	srcs := make([]reflect.Value, 0, len(fastPathTypes))
+	for _, t := range fastPathTypes {
+		v := reflect.New(t).Elem()
+		switch TypeClass(t.Kind()) {
+		case BoolClass:
+			v.SetBool(b)
+		case StringClass:
+			v.SetString(str)
+		case IntClass:
+			v.SetInt(n)
+		case UintClass:
+			v.SetUint(uint64(n))
+		case FloatClass:
+			v.SetFloat(f)
+		}
+		srcs = append(srcs, v)
+	}
+	return srcs
+}
+
+func sameBits(a, b reflect.Value) bool {
+	if TypeClass(a.Kind()) == FloatClass {
+		return math.Float64bits(a.Float()) == math.Float64bits(b.Float())
+	}
+	return a.Interface() == b.Interface()
+}
+
+func FuzzScalarFastPath(f *testing.F) {
+	f.Add(true, "s", int64(-1), 1.5)
+	f.Add(false, "", int64(math.MaxInt64), math.Inf(-1))
+	f.Add(false, "x", int64(math.MinInt64), math.NaN())
+	f.Add(true, "y", int64(1<<53+1), -0.0)
+	f.Add(true, "z", int64(16777217), 3.4e39)
+	f.Fuzz(func(t *testing.T, b bool, str string, n int64, fl float64) {
+		for _, s := range fastPathSources(b, str, n, fl) {
+			for _, to := range fastPathTypes {
+				d := reflect.New(to).Elem()
+				assigned := assignScalarFast(d, s)
+				if TypeCompatibility(s.Type(), to) == Incompatible {
+					if assigned {
+						t.Errorf("%s to %s should not be assigned", s.Type(), to)
+					}
+					continue
+				}
+				if !assigned {
+					t.Errorf("%s to %s should be assigned", s.Type(), to)
+				} else if expected := s.Convert(to); !sameBits(d, expected) {
+					t.Errorf("%s %v to %s: %v, expected %v", s.Type(), s, to, d, expected)
+				}
+			}
+		}
+	})
+}
+
+type EmbeddedLabels map[string]string
+
+type embeddedMapStruct struct {
+	EmbeddedLabels
+	Name string `map:"name"`
+}
+
+type embeddedMapWildcardStruct struct {
+	EmbeddedLabels
+	Name  string                 `map:"name"`
+	Extra map[string]interface{} `map:"*"`
+}
+
+func TestMapEmbeddedMap(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	src := map[string]interface{}{
+		"name":  "n",
+		"env":   "prod",
+		"count": 1,
+	}
+	var s embeddedMapStruct
+	if a.NoError(m.Map(&s, src)) {
+		a.Equal("n", s.Name)
+		a.Equal(EmbeddedLabels{"env": "prod"}, s.EmbeddedLabels)
+	}
+	var w embeddedMapWildcardStruct
+	if a.NoError(m.Map(&w, src)) {
+		a.Nil(w.EmbeddedLabels)
+		a.Equal(map[string]interface{}{"env": "prod", "count": 1}, w.Extra)
+	}
+}
+
+type sharedServer struct {
+	Host string `map:"host"`
+	Port int    `map:"port"`
+}
+
+type sharedConfig struct {
+	Base    *sharedServer `map:"base"`
+	Primary *sharedServer `map:"primary"`
+	Backup  *sharedServer `map:"backup"`
+	Other   *sharedServer `map:"other"`
+}
+
+func TestMapSharePointers(t *testing.T) {
+	a := assert.New(t)
+	// like the anchored mapping and aliases decoded by yamlv3.Decoder
+	base := map[string]interface{}{"host": "localhost", "port": 80}
+	data := map[string]interface{}{
+		"base":    base,
+		"primary": base,
+		"backup":  base,
+		"other":   map[string]interface{}{"host": "localhost", "port": 81},
+	}
+	m := tracedMapper(t)
+	m.SharePointers = true
+	var c sharedConfig
+	if a.NoError(m.Map(&c, data)) {
+		a.Equal(&sharedServer{Host: "localhost", Port: 80}, c.Base)
+		a.True(c.Base == c.Primary)
+		a.True(c.Base == c.Backup)
+		a.Equal(&sharedServer{Host: "localhost", Port: 81}, c.Other)
+	}
+	m.SharePointers = false
+	c = sharedConfig{}
+	if a.NoError(m.Map(&c, data)) {
+		a.Equal(c.Base, c.Primary)
+		a.False(c.Base == c.Primary)
+	}
+}
+
+type resetOuter struct {
+	Name string  `map:"name"`
+	Sub  struct1 `map:"sub"`
+}
+
+type defaultsStruct struct {
+	Name  string `map:"name"`
+	Level int    `map:"level"`
+}
+
+func (s *defaultsStruct) BeforeMap() error {
+	s.Level = 1
+	return nil
+}
+
+func TestMapEmptyMapResetsStructBeforeMap(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	m.EmptyMapResetsStruct = true
+	d := struct {
+		Sub defaultsStruct `map:"sub"`
+	}{Sub: defaultsStruct{Name: "n", Level: 5}}
+	if a.NoError(m.Map(&d, map[string]interface{}{"sub": map[string]interface{}{}})) {
+		a.Equal(defaultsStruct{Level: 1}, d.Sub)
+	}
+}
+
+func TestMapEmptyMapResetsStruct(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	for _, reset := range []bool{false, true} {
+		m.EmptyMapResetsStruct = reset
+		prefilled := func() resetOuter { return resetOuter{Sub: struct1{Str: "s"}} }
+
+		// absent key leaves the struct untouched
+		d := prefilled()
+		if a.NoError(m.Map(&d, map[string]interface{}{"name": "n"})) {
+			a.Equal("s", d.Sub.Str)
+		}
+		// null leaves the struct untouched
+		d = prefilled()
+		if a.NoError(m.Map(&d, map[string]interface{}{"sub": nil})) {
+			a.Equal("s", d.Sub.Str)
+		}
+		// empty map only resets the struct with EmptyMapResetsStruct
+		d = prefilled()
+		if a.NoError(m.Map(&d, map[string]interface{}{"sub": map[string]interface{}{}})) {
+			if reset {
+				a.Equal(struct1{}, d.Sub)
+			} else {
+				a.Equal("s", d.Sub.Str)
+			}
+		}
+	}
+}
+
+// Optional is the recipe for generic wrappers implementing ValueReceiver
+type Optional[T any] struct {
+	Value   T
+	Present bool
+}
+
+func (o *Optional[T]) ValuePtr() interface{} {
+	return &o.Value
+}
+
+func (o *Optional[T]) ValueReceived() {
+	o.Present = true
+}
+
+type Pair[K comparable, V any] struct {
+	Key K `map:"key"`
+	Val V `map:"val"`
+}
+
+type genericStruct struct {
+	Port    Optional[int]                 `map:"port"`
+	Name    Optional[string]              `map:"name"`
+	Server  Optional[sharedServer]        `map:"server"`
+	Missing Optional[int]                 `map:"missing"`
+	Pairs   []Pair[string, Optional[int]] `map:"pairs"`
+}
+
+func TestMapGenericTypes(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	var s genericStruct
+	src := map[string]interface{}{
+		"port":   8080,
+		"name":   nil,
+		"server": map[string]interface{}{"host": "h"},
+		"pairs":  []interface{}{map[string]interface{}{"key": "k", "val": 1}},
+	}
+	if a.NoError(m.Map(&s, src)) {
+		a.Equal(Optional[int]{Value: 8080, Present: true}, s.Port)
+		a.False(s.Name.Present)
+		a.Equal(Optional[sharedServer]{Value: sharedServer{Host: "h"}, Present: true}, s.Server)
+		a.False(s.Missing.Present)
+		a.Equal([]Pair[string, Optional[int]]{{Key: "k", Val: Optional[int]{Value: 1, Present: true}}}, s.Pairs)
+	}
+	err := m.Map(&s, map[string]interface{}{"port": "x"})
+	if a.Error(err) {
+		a.Contains(err.Error(), "*.Port")
+	}
+	name, ok := m.MapNameOf(reflect.TypeOf(Pair[string, int]{}), "Val")
+	a.True(ok)
+	a.Equal("val", name)
+}
+
+func TestMapGuard(t *testing.T) {
+	a := assert.New(t)
+	m := &Mapper{}
+	errForbidden := errors.New("forbidden")
+	var locs []string
+	m.Guard = func(d, s reflect.Value, loc string) error {
+		locs = append(locs, loc)
+		if strings.HasSuffix(loc, ".Str") {
+			return errForbidden
+		}
+		return nil
+	}
+	var s struct1
+	err := m.Map(&s, map[string]interface{}{"Str": "s"})
+	a.True(errors.Is(err, errForbidden))
+	a.Equal("", s.Str)
+	a.Equal([]string{"", "*", "*.Str"}, locs)
+
+	locs = nil
+	if a.NoError(m.Map(&s, map[string]interface{}{"strptr": "p"})) {
+		a.Equal("p", *s.StrPtr)
+		a.Contains(locs, "*.StrPtr")
+	}
+}
+
+type userV1 struct {
+	Name    string            `map:"name"`
+	Email   string            `map:"email"`
+	Age     int               `map:"age"`
+	Tags    []string          `map:"tags"`
+	Address *envServer        `map:"address"`
+	Extra   map[string]string `map:"extra"`
+}
+
+type userBase struct {
+	Name string `map:"name"`
+}
+
+type userV2 struct {
+	userBase
+	Email   string     `map:"email"`
+	Age     int64      `map:"age"`
+	Tags    []string   `map:"tags"`
+	Address *envServer `map:"address"`
+	Phone   string     `map:"phone"`
+}
+
+func TestMapStructToStruct(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	v1 := userV1{
+		Name:    "n",
+		Email:   "e",
+		Age:     10,
+		Tags:    []string{"a"},
+		Address: &envServer{Host: "h"},
+		Extra:   map[string]string{"k": "v"},
+	}
+	v2 := userV2{Phone: "p"}
+	if a.NoError(m.Map(&v2, v1)) {
+		a.Equal(userV2{
+			userBase: userBase{Name: "n"},
+			Email:    "e",
+			Age:      10,
+			Tags:     []string{"a"},
+			Address:  &envServer{Host: "h"},
+			Phone:    "p",
+		}, v2)
+	}
+	var v2s []userV2
+	if a.NoError(m.Map(&v2s, []userV1{v1, {Name: "m"}})) && a.Len(v2s, 2) {
+		a.Equal("m", v2s[1].Name)
+	}
+	var back userV1
+	if a.NoError(m.Map(&back, &v2)) {
+		a.Equal("n", back.Name)
+		a.Nil(back.Extra)
+	}
+	var mismatch struct {
+		Age string `map:"age"`
+	}
+	err := m.Map(&mismatch, v1)
+	if a.Error(err) {
+		a.Contains(err.Error(), "*.Age")
+	}
+}
+
+func TestMapStructToStructNoCommonFields(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	var dst struct {
+		Server *envServer `map:"server"`
+	}
+	src := struct {
+		Server kvPair `map:"server"`
+	}{Server: kvPair{Key: "k"}}
+	err := m.Map(&dst, src)
+	var mismatch *MismatchError
+	if a.True(errors.As(err, &mismatch)) {
+		a.Equal("*.Server*", mismatch.Loc)
+	}
+	a.Nil(dst.Server)
+}
+
+func TestMapStructToStructMergeStrategy(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	m.MergeStrategy = DestWins
+	v2 := userV2{Email: "keep"}
+	if a.NoError(m.Map(&v2, userV1{Name: "n", Email: "e"})) {
+		a.Equal("keep", v2.Email)
+		a.Equal("n", v2.Name)
+	}
+	m.MergeStrategy = ErrorOnConflict
+	a.NoError(m.Map(&v2, userV1{Name: "n", Email: "keep"}))
+	var conflict *MergeConflictError
+	if a.True(errors.As(m.Map(&v2, userV1{Name: "n", Email: "e"}), &conflict)) {
+		a.Equal("*.Email", conflict.Loc)
+	}
+}
+
+type planTagsA struct {
+	X int `map:"x" json:"y"`
+}
+
+type planTagsB struct {
+	Y int `map:"y" json:"y"`
+}
+
+func TestMapStructToStructPlanPerMapper(t *testing.T) {
+	a := assert.New(t)
+	var b planTagsB
+	a.Error((&Mapper{}).Map(&b, planTagsA{X: 1}))
+	m := &Mapper{FieldTags: []string{"json"}}
+	if a.NoError(m.Map(&b, planTagsA{X: 1})) {
+		a.Equal(1, b.Y)
+	}
+	// the plans are cached in each Mapper
+	cached := 0
+	m.planCache.Range(func(key, value interface{}) bool {
+		cached++
+		return true
+	})
+	a.Equal(1, cached)
+	a.Error((&Mapper{}).Map(&b, planTagsA{X: 2}))
+}
+
+func BenchmarkMapStructToStruct(b *testing.B) {
+	m := &Mapper{}
+	src := make([]userV1, 1000)
+	for i := range src {
+		src[i] = userV1{Name: strconv.Itoa(i), Email: "e", Age: i, Tags: []string{"a", "b"}}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var dst []userV2
+		if err := m.Map(&dst, src); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+type kvPair struct {
+	Key   string
+	Value int
+}
+
+func TestMapToPairs(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	m.PairKeyField, m.PairValueField = "Key", "Value"
+	src := map[string]interface{}{"b": 2, "a": 1, "c": 3}
+	var pairs []kvPair
+	if a.NoError(m.Map(&pairs, src)) {
+		a.ElementsMatch([]kvPair{{"a", 1}, {"b", 2}, {"c", 3}}, pairs)
+	}
+	m.SortPairs = true
+	var ptrs []*kvPair
+	if a.NoError(m.Map(&ptrs, src)) {
+		a.Equal([]*kvPair{{"a", 1}, {"b", 2}, {"c", 3}}, ptrs)
+	}
+	err := m.Map(&pairs, map[string]interface{}{"x": "y"})
+	if a.Error(err) {
+		a.Contains(err.Error(), ".x")
+	}
+	m.PairValueField = ""
+	a.Error(m.Map(&pairs, src))
+}
+
+type annotatedStruct struct {
+	Shape shape       `map:"shape"`
+	Any   interface{} `map:"any"`
+	Nil   shape       `map:"nil"`
+	Name  string      `map:"name"`
+}
+
+func TestMapAnnotateTypes(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	m.AnnotateTypes = true
+	s := &annotatedStruct{Shape: &circleShape{Radius: 2}, Any: 1, Name: "n"}
+	out := make(map[string]interface{})
+	if a.NoError(m.Map(out, s)) {
+		a.Equal(map[string]interface{}{
+			"@type": "*mapper.circleShape",
+			"value": map[string]interface{}{"radius": 2.0},
+		}, out["shape"])
+		a.Equal(map[string]interface{}{"@type": "int", "value": 1}, out["any"])
+		a.Nil(out["nil"])
+		a.Equal("n", out["name"])
+	}
+	m.TypeKey, m.TypeValueKey = "kind", "data"
+	out = make(map[string]interface{})
+	if a.NoError(m.Map(out, &annotatedStruct{Shape: squareShape{Side: 1}})) {
+		a.Equal(map[string]interface{}{
+			"kind": "mapper.squareShape",
+			"data": map[string]interface{}{"side": 1.0},
+		}, out["shape"])
+	}
+}
+
+func TestMapFloatToInt(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	var ints []int
+	a.Error(m.Map(&ints, []interface{}{1.0, 2.0}))
+
+	m.FloatToInt = FloatToIntTruncate
+	if a.NoError(m.Map(&ints, []interface{}{1.0, 2.7, -2.7})) {
+		a.Equal([]int{1, 2, -2}, ints)
+	}
+	m.FloatToInt = FloatToIntRound
+	if a.NoError(m.Map(&ints, []interface{}{1.0, 2.5, -2.5})) {
+		a.Equal([]int{1, 3, -3}, ints)
+	}
+	im := make(map[string]int)
+	if a.NoError(m.Map(im, map[string]float64{"a": 2.5})) {
+		a.Equal(map[string]int{"a": 3}, im)
+	}
+	m.FloatToInt = FloatToIntExact
+	var s struct {
+		Count uint8 `map:"count"`
+	}
+	if a.NoError(m.Map(&s, map[string]interface{}{"count": 255.0})) {
+		a.EqualValues(255, s.Count)
+	}
+	var floatErr *FloatToIntError
+	if a.True(errors.As(m.Map(&s, map[string]interface{}{"count": 1.5}), &floatErr)) {
+		a.Equal("*.Count", floatErr.Loc)
+	}
+	a.True(errors.As(m.Map(&s, map[string]interface{}{"count": 256.0}), &floatErr))
+	a.True(errors.As(m.Map(&s, map[string]interface{}{"count": -1.0}), &floatErr))
+	a.True(errors.As(m.Map(&ints, []interface{}{math.NaN()}), &floatErr))
+	a.True(errors.As(m.Map(&ints, []interface{}{1e19}), &floatErr))
+}
+
+func TestMapElemOptionConversions(t *testing.T) {
+	a := assert.New(t)
+	cases := []struct {
+		m   *Mapper
+		src interface{}
+		dst interface{}
+	}{
+		{&Mapper{ParseBoolStrings: true}, "yes", true},
+		{&Mapper{FloatToInt: FloatToIntRound}, 2.5, uint8(3)},
+		{&Mapper{ComplexFormat: ComplexString}, "(1+2i)", complex(1, 2)},
+	}
+	for _, c := range cases {
+		d := reflect.New(reflect.TypeOf(c.dst)).Elem()
+		if a.NoError(c.m.Map(d.Addr().Interface(), c.src)) {
+			a.Equal(c.dst, d.Interface())
+		}
+		// a conversion performed on a value is also accepted for map elements
+		dm := reflect.MakeMap(reflect.MapOf(StringType, d.Type()))
+		sm := reflect.MakeMap(reflect.MapOf(StringType, reflect.TypeOf(c.src)))
+		sm.SetMapIndex(reflect.ValueOf("k"), reflect.ValueOf(c.src))
+		if a.NoError(c.m.Map(dm.Interface(), sm.Interface())) {
+			a.Equal(c.dst, dm.MapIndex(reflect.ValueOf("k")).Interface())
+		}
+		a.Error((&Mapper{}).Map(dm.Interface(), sm.Interface()))
+	}
+}
+
+func TestPathEscape(t *testing.T) {
+	a := assert.New(t)
+	a.Equal([]string{"a", "b"}, SplitPath("a.b"))
+	a.Equal([]string{"a.b", "c"}, SplitPath(`a\.b.c`))
+	a.Equal([]string{`a\`, "b"}, SplitPath(`a\\.b`))
+	for _, key := range []string{"a", "a.b", `a\b`, `a\.b.`} {
+		a.Equal([]string{"x", key}, SplitPath("x."+EscapePathKey(key)))
+	}
+
+	m := tracedMapper(t)
+	var s struct {
+		Labels map[string]string `map:"labels"`
+	}
+	src := map[string]interface{}{
+		"labels": map[string]interface{}{"app.io/name": "n", "app": "x"},
+	}
+	if a.NoError(m.MapFields(&s, src, []string{`labels.app\.io/name`})) {
+		a.Equal(map[string]string{"app.io/name": "n"}, s.Labels)
+	}
+	err := m.Map(&s, map[string]interface{}{"labels": map[string]interface{}{"a.b": 1}})
+	if a.Error(err) {
+		a.Contains(err.Error(), `*.Labels.a\.b`)
+	}
+	changes, err := Diff(map[string]interface{}{"a.b": 1}, map[string]interface{}{"a.b": 2})
+	if a.NoError(err) && a.Len(changes, 1) {
+		a.Equal([]string{"", "a.b"}, SplitPath(changes[0].Loc))
+	}
+}
+
+type valuesInner struct {
+	City string `map:"city"`
+}
+
+type valuesStruct struct {
+	Name    string        `map:"name"`
+	Age     int           `map:"age"`
+	Score   float64       `map:"score"`
+	Admin   bool          `map:"admin"`
+	Tags    []string      `map:"tags"`
+	Address valuesInner   `map:"address"`
+	Others  []valuesInner `map:"others"`
+	Created time.Time     `map:"created"`
+	Empty   *string       `map:"empty"`
+}
+
+func TestToValues(t *testing.T) {
+	a := assert.New(t)
+	s := &valuesStruct{
+		Name:    "n",
+		Age:     10,
+		Score:   1.5,
+		Admin:   true,
+		Tags:    []string{"a", "b"},
+		Address: valuesInner{City: "c"},
+		Others:  []valuesInner{{City: "d"}},
+		Created: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+	values, err := ToValues(s)
+	if a.NoError(err) {
+		a.Equal(url.Values{
+			"name":            {"n"},
+			"age":             {"10"},
+			"score":           {"1.5"},
+			"admin":           {"true"},
+			"tags":            {"a", "b"},
+			"address[city]":   {"c"},
+			"others[0][city]": {"d"},
+			"created":         {"2020-01-02T00:00:00Z"},
+		}, values)
+	}
+	m := &Mapper{FormKeyFormat: FormKeyDots}
+	values, err = m.ToValues(map[string]interface{}{"a": map[string]interface{}{"b": 1}})
+	if a.NoError(err) {
+		a.Equal(url.Values{"a.b": {"1"}}, values)
+	}
+
+	var back struct {
+		Name string   `map:"name"`
+		Tags []string `map:"tags"`
+	}
+	m = &Mapper{FormDecode: true}
+	values, _ = ToValues(map[string]interface{}{"name": "n", "tags": []string{"a", "b"}})
+	if a.NoError(m.Map(&back, values)) {
+		a.Equal("n", back.Name)
+		a.Equal([]string{"a", "b"}, back.Tags)
+	}
+}
+
+type fakeUUID [4]byte
+
+type omitTypesStruct struct {
+	ID     fakeUUID `map:"id"`
+	Parent fakeUUID `map:"parent,keepempty"`
+	Name   string   `map:"name"`
+	Count  int      `map:"count"`
+}
+
+func TestMapOmitEmptyTypes(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	m.OmitEmptyTypes = map[reflect.Type]bool{reflect.TypeOf(fakeUUID{}): true}
+	out := make(map[string]interface{})
+	if a.NoError(m.Map(out, &omitTypesStruct{})) {
+		a.Equal(map[string]interface{}{
+			"parent": fakeUUID{},
+			"name":   "",
+			"count":  0,
+		}, out)
+	}
+	out = make(map[string]interface{})
+	if a.NoError(m.Map(out, &omitTypesStruct{ID: fakeUUID{1}})) {
+		a.Equal(fakeUUID{1}, out["id"])
+	}
+	m.OmitEmptyAll = true
+	m.OmitEmptyTypes = map[reflect.Type]bool{reflect.TypeOf(0): false}
+	out = make(map[string]interface{})
+	if a.NoError(m.Map(out, &omitTypesStruct{})) {
+		a.Equal(map[string]interface{}{
+			"parent": fakeUUID{},
+			"count":  0,
+		}, out)
+	}
+}
+
+func TestMapValueUnaddressable(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	var s struct1
+	src := reflect.ValueOf(map[string]interface{}{"Str": "s"})
+	var addrErr *UnaddressableError
+	if a.True(errors.As(m.MapValue(reflect.ValueOf(s), src), &addrErr)) {
+		a.Equal(reflect.TypeOf(s), addrErr.Type)
+		a.Contains(addrErr.Error(), "&v")
+	}
+	a.True(errors.As(m.Map(s, src.Interface()), &addrErr))
+	if a.NoError(m.MapValue(reflect.ValueOf(&s), src)) {
+		a.Equal("s", s.Str)
+	}
+	s.Str = ""
+	if a.NoError(m.MapValue(reflect.ValueOf(&s).Elem(), src)) {
+		a.Equal("s", s.Str)
+	}
+	out := make(map[string]interface{})
+	a.NoError(m.MapValue(reflect.ValueOf(out), src))
+}
+
+type triStateStruct struct {
+	Enabled *bool `map:"enabled,omitempty"`
+}
+
+func TestMapTriStateBool(t *testing.T) {
+	a := assert.New(t)
+	for _, m := range []*Mapper{tracedMapper(t), {}} {
+		var s triStateStruct
+		if a.NoError(m.Map(&s, map[string]interface{}{"enabled": false})) && a.NotNil(s.Enabled) {
+			a.False(*s.Enabled)
+		}
+		var absent triStateStruct
+		if a.NoError(m.Map(&absent, map[string]interface{}{})) {
+			a.Nil(absent.Enabled)
+		}
+		var null triStateStruct
+		if a.NoError(m.Map(&null, map[string]interface{}{"enabled": nil})) {
+			a.Nil(null.Enabled)
+		}
+
+		m.OmitEmptyAll = true
+		out := make(map[string]interface{})
+		if a.NoError(m.Map(out, &s)) {
+			a.Equal(s.Enabled, out["enabled"])
+		}
+		out = make(map[string]interface{})
+		if a.NoError(m.Map(out, &absent)) {
+			a.NotContains(out, "enabled")
+		}
+	}
+}
+
+type reportStruct struct {
+	Name   string     `map:"name"`
+	Count  int        `map:"count"`
+	Nested *struct1   `map:"nested"`
+	Server envServer  `map:"server"`
+	Extra  *envServer `map:"extra"`
+}
+
+func TestMapReport(t *testing.T) {
+	a := assert.New(t)
+	m := tracedMapper(t)
+	var s reportStruct
+	report, err := m.MapReport(&s, map[string]interface{}{
+		"name":    "n",
+		"extra":   nil,
+		"unknown": 1,
+		"nested":  map[string]interface{}{"Str": "s", "typo": 1},
+		"server":  map[string]interface{}{"host": "h"},
+	})
+	if a.NoError(err) {
+		a.ElementsMatch([]string{"*.Name", "*.Nested*.Str", "*.Nested", "*.Server.Host", "*.Server"}, report.Applied)
+		a.ElementsMatch([]string{"*.Count", "*.Nested*.StrPtr", "*.Nested*.FloatPtr", "*.Server.Port", "*.Extra"}, report.Skipped)
+		a.Empty(report.Errors)
+		a.Equal([]string{"*.Nested*.typo", "*.unknown"}, report.Unknown)
+	}
+	report, err = m.MapReport(&s, map[string]interface{}{"count": "x"})
+	if a.Error(err) {
+		a.Contains(report.Errors, "*.Count")
+	}
+	a.Nil(m.report)
+}
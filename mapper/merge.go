@@ -0,0 +1,55 @@
+package mapper
+
+import "reflect"
+
+// MergeStrategy determines how conflicting values are merged
+//
+// Maps and structs are always merged recursively. A conflict happens
+// when the destination already has a non-nil value for a map key, or a
+// non-empty value for a struct field, which can't be merged recursively,
+// e.g. scalars and slices.
+type MergeStrategy int
+
+// Merge strategies
+const (
+	// SourceWins replaces the destination value, slices are replaced
+	SourceWins MergeStrategy = iota
+	// DestWins keeps the destination value, slices are kept
+	DestWins
+	// ErrorOnConflict fails unless the source value equals the destination
+	// value after conversion, slices are compared as a whole
+	ErrorOnConflict
+)
+
+func errMergeConflict(loc string) error {
+	return &MergeConflictError{Loc: loc}
+}
+
+// resolveConflict determines whether the existing value d should be kept
+// according to the MergeStrategy
+func (m *Mapper) resolveConflict(d, s reflect.Value, loc string) (keep bool, err error) {
+	switch m.MergeStrategy {
+	case DestWins:
+		return true, nil
+	case ErrorOnConflict:
+		if !UnwrapAny(s).IsValid() {
+			return true, nil
+		}
+		v := reflect.New(d.Type()).Elem()
+		if _, e := m.assignValue(v, s, loc); e != nil {
+			// let the actual assignment report the error
+			return false, nil
+		}
+		if reflect.DeepEqual(UnwrapAny(v).Interface(), UnwrapAny(d).Interface()) {
+			return true, nil
+		}
+		return false, errMergeConflict(loc)
+	}
+	return false, nil
+}
+
+// Merge merges src into dst with the specified strategy
+func Merge(dst, src interface{}, strategy MergeStrategy) error {
+	m := &Mapper{MergeStrategy: strategy}
+	return m.Map(dst, src)
+}
@@ -1,6 +1,9 @@
 package mapper
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+)
 
 // StringifyKeys converts keys to strings
 func StringifyKeys(val interface{}) interface{} {
@@ -22,3 +25,57 @@ func StringifyKeys(val interface{}) interface{} {
 	}
 	return val
 }
+
+// KeyCollisionError indicates distinct keys are converted to the same string
+type KeyCollisionError struct {
+	Key string
+	// Path is the dotted path of the key, e.g. servers.0.1
+	Path string
+}
+
+// Error implements error
+func (e *KeyCollisionError) Error() string {
+	return fmt.Sprintf("key collision %q [%s]", e.Key, e.Path)
+}
+
+// StringifyKeysStrict converts keys to strings like StringifyKeys,
+// and fails if distinct keys are converted to the same string,
+// e.g. true and "true". Unlike StringifyKeys, new maps and slices are
+// built, so the input is left unchanged, even on error
+func StringifyKeysStrict(val interface{}) (interface{}, error) {
+	return stringifyKeysStrict(val, "")
+}
+
+func stringifyKeysStrict(val interface{}, path string) (out interface{}, err error) {
+	switch v := val.(type) {
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for n, item := range v {
+			if items[n], err = stringifyKeysStrict(item, joinPath(path, strconv.Itoa(n))); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			str := fmt.Sprintf("%v", key)
+			if _, exist := m[str]; exist {
+				return nil, &KeyCollisionError{Key: str, Path: joinPath(path, str)}
+			}
+			if m[str], err = stringifyKeysStrict(value, joinPath(path, str)); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			if m[key], err = stringifyKeysStrict(value, joinPath(path, key)); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+	return val, nil
+}
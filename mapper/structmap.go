@@ -0,0 +1,144 @@
+package mapper
+
+import "reflect"
+
+// structPlanKey identifies a plan by the type pair and the field tags,
+// as the map names depend on Mapper.FieldTags and Mapper.OptionsTag
+type structPlanKey struct {
+	typePair
+	tags string
+}
+
+// structFieldPath locates a field, possibly promoted from anonymous or
+// squashed structs, with the fields along the path for composing the loc
+type structFieldPath struct {
+	index  []int
+	fields []reflect.StructField
+	infos  []*FieldInfo
+	depth  int
+}
+
+// structPlan pairs the fields of the destination and source struct types
+// by the map names
+type structPlan struct {
+	dst []*structFieldPath
+	src []*structFieldPath
+}
+
+// structPlanOf returns the plan for mapping between the struct types,
+// cached in the Mapper
+func (m *Mapper) structPlanOf(from, to reflect.Type) *structPlan {
+	key := structPlanKey{typePair: typePair{from: from, to: to}, tags: m.tagsKey()}
+	if plan, ok := m.planCache.Load(key); ok {
+		return plan.(*structPlan)
+	}
+	srcFields := make(map[string]*structFieldPath)
+	m.collectFieldPaths(from, nil, func(name string, p *structFieldPath) {
+		// the first field of the shallowest depth is the source
+		if exist, ok := srcFields[name]; !ok || p.depth < exist.depth {
+			srcFields[name] = p
+		}
+	})
+	var dstFields []*structFieldPath
+	depths := make(map[string]int)
+	m.collectFieldPaths(to, nil, func(name string, p *structFieldPath) {
+		dstFields = append(dstFields, p)
+	})
+	for _, p := range dstFields {
+		name := p.infos[len(p.infos)-1].MapName
+		if d, ok := depths[name]; !ok || p.depth < d {
+			depths[name] = p.depth
+		}
+	}
+	plan := &structPlan{}
+	for _, p := range dstFields {
+		name := p.infos[len(p.infos)-1].MapName
+		if src, ok := srcFields[name]; ok && p.depth == depths[name] {
+			// all destination fields of the shallowest depth are mapped
+			plan.dst = append(plan.dst, p)
+			plan.src = append(plan.src, src)
+		}
+	}
+	m.planCache.Store(key, plan)
+	return plan
+}
+
+// collectFieldPaths walks the mapped fields of the struct type, including
+// the fields promoted from anonymous and squashed structs
+func (m *Mapper) collectFieldPaths(t reflect.Type, parent *structFieldPath, collect func(name string, p *structFieldPath)) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		info := m.ParseField(field)
+		p := &structFieldPath{}
+		if parent != nil {
+			p.index = append(p.index, parent.index...)
+			p.fields = append(p.fields, parent.fields...)
+			p.infos = append(p.infos, parent.infos...)
+			p.depth = parent.depth
+		}
+		p.index = append(p.index, i)
+		p.fields = append(p.fields, field)
+		p.infos = append(p.infos, info)
+		if (field.Anonymous || info.Squash) && field.Type.Kind() == reflect.Struct {
+			p.depth = embedDepth(field, p.depth)
+			m.collectFieldPaths(field.Type, p, collect)
+		} else if info.Exported && !info.Ignore && !info.Wildcard && info.Pattern == "" && info.MapName != "" {
+			collect(info.MapName, p)
+		}
+	}
+}
+
+// locFieldPath composes the loc of a field along the path
+func (m *Mapper) locFieldPath(loc string, p *structFieldPath) string {
+	for i, field := range p.fields {
+		loc = m.locField(loc, field, p.infos[i])
+	}
+	return loc
+}
+
+// assignStructToStruct maps the fields of a struct into the fields of
+// another struct type by the map names, using the plan cached per pair of
+// struct types. Like mapping from a map, the non-empty scalar fields are
+// merged by MergeStrategy
+func (m *Mapper) assignStructToStruct(d, s reflect.Value, loc string) (assigned bool, err error) {
+	plan := m.structPlanOf(s.Type(), d.Type())
+	if len(plan.dst) == 0 {
+		// the types share no fields, which is likely a mix-up
+		return false, nil
+	}
+	if err = callBeforeMap(d, loc); err != nil {
+		return false, err
+	}
+	for i, dp := range plan.dst {
+		if m.isPreserved(dp.fields[len(dp.fields)-1].Type) {
+			continue
+		}
+		df := d.FieldByIndex(dp.index)
+		sf := s.FieldByIndex(plan.src[i].index)
+		fieldLoc := m.locFieldPath(loc, dp)
+		if fv := UnwrapAny(df); m.MergeStrategy != SourceWins &&
+			fv.IsValid() && !m.isContainer(fv) && !m.isEmpty(fv) {
+			keep, err := m.resolveConflict(df, sf, fieldLoc)
+			if err != nil {
+				return false, err
+			}
+			if keep {
+				assigned = true
+				continue
+			}
+		}
+		if m.assignScalarField(df, sf) {
+			assigned = true
+			continue
+		}
+		a, err := m.assignValue(df, sf, fieldLoc)
+		if err != nil {
+			return false, err
+		}
+		assigned = assigned || a
+	}
+	if err = callAfterMap(d, loc); err != nil {
+		return false, err
+	}
+	return
+}
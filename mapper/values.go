@@ -0,0 +1,136 @@
+package mapper
+
+import (
+	"encoding"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// FormKeyFormat defines how the keys of nested values are composed
+// in form values
+type FormKeyFormat int
+
+// Form key formats
+const (
+	// FormKeyBrackets composes keys like "a[b]" and "a[0][b]"
+	FormKeyBrackets FormKeyFormat = iota
+	// FormKeyDots composes keys like "a.b" and "a.0.b"
+	FormKeyDots
+)
+
+// ToValues flattens a struct or map into url.Values with a default Mapper
+func ToValues(s interface{}) (url.Values, error) {
+	return (&Mapper{}).ToValues(s)
+}
+
+// ToValues flattens a struct or map into url.Values, the reverse of
+// FormDecode. Structs are mapped into maps, scalars are converted to
+// strings, and slices of scalars to repeated values. The keys of nested
+// maps and slices of containers are composed by FormKeyFormat
+func (m *Mapper) ToValues(s interface{}) (url.Values, error) {
+	values := make(url.Values)
+	if err := m.flattenValues(values, "", reflect.ValueOf(s)); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func (m *Mapper) formKey(prefix, key string) string {
+	switch {
+	case prefix == "":
+		return key
+	case m.FormKeyFormat == FormKeyDots:
+		return prefix + "." + key
+	}
+	return prefix + "[" + key + "]"
+}
+
+func (m *Mapper) flattenValues(values url.Values, prefix string, v reflect.Value) error {
+	v = UnwrapAny(v)
+	if !v.IsValid() {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		if isTextStruct(v.Type()) {
+			break
+		}
+		out := make(map[string]interface{})
+		if _, err := m.assignValue(reflect.ValueOf(out), v, prefix); err != nil {
+			return err
+		}
+		return m.flattenValues(values, prefix, reflect.ValueOf(out))
+	case reflect.Map:
+		keys := make([]string, 0, v.Len())
+		mapKeys := make(map[string]reflect.Value, v.Len())
+		for _, key := range v.MapKeys() {
+			name := fmt.Sprintf("%v", UnwrapAny(key))
+			keys = append(keys, name)
+			mapKeys[name] = key
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := m.flattenValues(values, m.formKey(prefix, key), v.MapIndex(mapKeys[key])); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Slice, reflect.Array:
+		if isByteSlice(v.Type()) {
+			break
+		}
+		for i := 0; i < v.Len(); i++ {
+			elem := UnwrapAny(v.Index(i))
+			if !elem.IsValid() {
+				continue
+			}
+			key := prefix
+			if IsContainer(elem) && !isTextStruct(elem.Type()) || elem.Kind() == reflect.Slice && !isByteSlice(elem.Type()) {
+				key = m.formKey(prefix, strconv.Itoa(i))
+			}
+			if err := m.flattenValues(values, key, elem); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	str, err := m.formString(v, prefix)
+	if err != nil {
+		return err
+	}
+	values.Add(prefix, str)
+	return nil
+}
+
+// formString converts a scalar to the string form
+func (m *Mapper) formString(v reflect.Value, loc string) (string, error) {
+	switch TypeClass(v.Kind()) {
+	case StringClass:
+		return v.String(), nil
+	case BoolClass:
+		return strconv.FormatBool(v.Bool()), nil
+	case IntClass:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case UintClass:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case FloatClass:
+		return strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits()), nil
+	}
+	if v.CanInterface() {
+		if tm, ok := v.Interface().(encoding.TextMarshaler); ok {
+			text, err := tm.MarshalText()
+			if err != nil {
+				return "", errMarshal(err, loc)
+			}
+			return string(text), nil
+		}
+		if isByteSlice(v.Type()) {
+			return fmt.Sprintf("%v", m.formatBytes(v).Interface()), nil
+		}
+		return fmt.Sprintf("%v", v.Interface()), nil
+	}
+	return "", errMismatch(v.Type(), StringType, loc)
+}
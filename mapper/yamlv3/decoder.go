@@ -0,0 +1,173 @@
+// Package yamlv3 provides a mapper.Decoder using gopkg.in/yaml.v3,
+// separated from package mapper to keep yaml.v3 an optional dependency
+package yamlv3
+
+import (
+	"fmt"
+
+	"github.com/codingbrain/mapper.go/mapper"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Decoder decodes content in YAML using the node API of yaml.v3.
+// Unlike mapper.YAMLDecoder, an anchored mapping and all its aliases
+// decode into the same map, so with Mapper.SharePointers they are mapped
+// into the same pointer destination. Keys are the literal text of the
+// scalar keys
+type Decoder struct {
+	// MaxDepth limits the nesting levels of maps and sequences,
+	// and zero means unlimited
+	MaxDepth int
+}
+
+// nodeDecoder converts the nodes into maps, slices and scalars,
+// and keeps the values of anchored nodes for the aliases
+type nodeDecoder struct {
+	anchored map[*yaml.Node]interface{}
+	// heights are the nesting levels of the anchored values,
+	// as an alias may place a value deeper than its anchor
+	heights  map[*yaml.Node]int
+	maxDepth int
+}
+
+// Decode implements mapper.Decoder
+func (d *Decoder) Decode(content []byte) (out interface{}, err error) {
+	var doc yaml.Node
+	if err = yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	dec := &nodeDecoder{
+		anchored: make(map[*yaml.Node]interface{}),
+		heights:  make(map[*yaml.Node]int),
+		maxDepth: d.MaxDepth,
+	}
+	if out, _, err = dec.decode(&doc, 0); err != nil {
+		return nil, err
+	}
+	if out == nil {
+		out = make(map[string]interface{})
+	}
+	return out, nil
+}
+
+// decode converts the node under depth levels of mappings and sequences,
+// and returns the nesting levels of the value. MaxDepth is checked while
+// decoding, instead of after the whole content is decoded
+func (d *nodeDecoder) decode(n *yaml.Node, depth int) (v interface{}, height int, err error) {
+	switch n.Kind {
+	case 0:
+		return nil, 0, nil
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return nil, 0, nil
+		}
+		return d.decode(n.Content[0], depth)
+	case yaml.AliasNode:
+		return d.decode(n.Alias, depth)
+	}
+	if v, ok := d.anchored[n]; ok {
+		height = d.heights[n]
+		return v, height, d.checkDepth(depth + height)
+	}
+	switch n.Kind {
+	case yaml.MappingNode:
+		if err = d.checkDepth(depth + 1); err != nil {
+			return nil, 0, err
+		}
+		out := make(map[string]interface{})
+		if n.Anchor != "" {
+			d.anchored[n] = out
+		}
+		height, err = d.decodeMapping(n, out, depth+1)
+		v = out
+	case yaml.SequenceNode:
+		if err = d.checkDepth(depth + 1); err != nil {
+			return nil, 0, err
+		}
+		items := make([]interface{}, len(n.Content))
+		for i, item := range n.Content {
+			var h int
+			if items[i], h, err = d.decode(item, depth+1); err != nil {
+				return nil, 0, err
+			}
+			if h > height {
+				height = h
+			}
+		}
+		if n.Anchor != "" {
+			d.anchored[n] = items
+		}
+		v = items
+	default:
+		if err = n.Decode(&v); err != nil {
+			return nil, 0, err
+		}
+		return v, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	height++
+	if n.Anchor != "" {
+		d.heights[n] = height
+	}
+	return v, height, nil
+}
+
+func (d *nodeDecoder) checkDepth(depth int) error {
+	if d.maxDepth > 0 && depth > d.maxDepth {
+		return &mapper.DepthLimitError{MaxDepth: d.maxDepth}
+	}
+	return nil
+}
+
+// decodeMapping decodes the key/value pairs at the depth of the mapping
+// into out, and returns the nesting levels of the values. Merge keys "<<"
+// only fill in the keys not explicitly defined
+func (d *nodeDecoder) decodeMapping(n *yaml.Node, out map[string]interface{}, depth int) (height int, err error) {
+	var merges []*yaml.Node
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, val := n.Content[i], n.Content[i+1]
+		if key.Kind == yaml.ScalarNode && key.Tag == "!!merge" {
+			merges = append(merges, val)
+			continue
+		}
+		if key.Kind != yaml.ScalarNode {
+			return 0, fmt.Errorf("line %d: unsupported non-scalar key", key.Line)
+		}
+		v, h, err := d.decode(val, depth)
+		if err != nil {
+			return 0, err
+		}
+		if h > height {
+			height = h
+		}
+		out[key.Value] = v
+	}
+	for _, merge := range merges {
+		sources := []*yaml.Node{merge}
+		if merge.Kind == yaml.SequenceNode {
+			sources = merge.Content
+		}
+		for _, source := range sources {
+			// the merged values are at the depth of the mapping
+			v, h, err := d.decode(source, depth-1)
+			if err != nil {
+				return 0, err
+			}
+			if h-1 > height {
+				height = h - 1
+			}
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return 0, fmt.Errorf("line %d: merge value is not a map", source.Line)
+			}
+			for key, val := range m {
+				if _, exists := out[key]; !exists {
+					out[key] = val
+				}
+			}
+		}
+	}
+	return height, nil
+}
@@ -0,0 +1,69 @@
+package yamlv3
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/codingbrain/mapper.go/mapper"
+	"github.com/stretchr/testify/assert"
+)
+
+type server struct {
+	Host string `map:"host"`
+	Port int    `map:"port"`
+}
+
+type config struct {
+	Base    *server `map:"base"`
+	Primary *server `map:"primary"`
+	Backup  *server `map:"backup"`
+	Other   *server `map:"other"`
+}
+
+func TestDecodeAnchors(t *testing.T) {
+	a := assert.New(t)
+	content := `
+base: &base
+  host: localhost
+  port: 80
+primary: *base
+backup: *base
+other:
+  <<: *base
+  port: 81
+`
+	data, err := (&Decoder{}).Decode([]byte(content))
+	if !a.NoError(err) {
+		return
+	}
+	m := &mapper.Mapper{SharePointers: true}
+	var c config
+	if a.NoError(m.Map(&c, data)) {
+		a.Equal(&server{Host: "localhost", Port: 80}, c.Base)
+		a.True(c.Base == c.Primary)
+		a.True(c.Base == c.Backup)
+		a.Equal(&server{Host: "localhost", Port: 81}, c.Other)
+	}
+}
+
+func TestDecodeMaxDepth(t *testing.T) {
+	a := assert.New(t)
+	d := &Decoder{MaxDepth: 2}
+	_, err := d.Decode([]byte("a:\n  b: 1\n"))
+	a.NoError(err)
+	var depthErr *mapper.DepthLimitError
+	_, err = d.Decode([]byte("a:\n  b:\n    c: 1\n"))
+	if a.True(errors.As(err, &depthErr)) {
+		a.Equal(2, depthErr.MaxDepth)
+	}
+	_, err = d.Decode([]byte("- - - 1\n"))
+	a.True(errors.As(err, &depthErr))
+	// the alias places the anchored mapping deeper than the anchor
+	_, err = d.Decode([]byte("a: &a\n  b: 1\nc:\n  d: *a\n"))
+	a.True(errors.As(err, &depthErr))
+	// the merged values are at the depth of the mapping
+	_, err = d.Decode([]byte("a: &a\n  b: 1\nc:\n  <<: *a\n"))
+	a.NoError(err)
+	_, err = d.Decode([]byte("a: &a\n  b: {x: 1}\nc:\n  <<: *a\n"))
+	a.True(errors.As(err, &depthErr))
+}